@@ -0,0 +1,155 @@
+package evidence
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// printArea tracks the bottom-right corner of the content placed in a sheet
+type printArea struct {
+	lastCol, lastRow int
+}
+
+// include grows the area to cover the cell at col, row
+func (a *printArea) include(col, row int) {
+	if a == nil {
+		return
+	}
+	a.lastCol = max(a.lastCol, col)
+	a.lastRow = max(a.lastRow, row)
+}
+
+// placedWidth records the width in pixels, including its offset in the cell,
+// of the last image placed, for layouts that advance by the image size
+type placedWidth struct {
+	pixels float64
+}
+
+// set records the width of the image just placed
+func (w *placedWidth) set(pixels float64) {
+	if w != nil {
+		w.pixels = pixels
+	}
+}
+
+// includeImage grows the area to cover an image of the given size in pixels
+// anchored at the cell
+func (a *printArea) includeImage(f *excelize.File, sheetName, cellName string, width, height float64) error {
+	if a == nil {
+		return nil
+	}
+	lastCol, lastRow, err := imageExtent(f, sheetName, cellName, width, height)
+	if err != nil {
+		return err
+	}
+	a.include(lastCol, lastRow)
+	return nil
+}
+
+// imageExtent returns the column and row of the bottom-right cell covered by
+// an image of the given size in pixels anchored at the cell
+func imageExtent(f *excelize.File, sheetName, cellName string, width, height float64) (int, int, error) {
+	col, row, err := excelize.CellNameToCoordinates(cellName)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Walk right and down until the columns and rows cover the image
+	for ; width > 0; col++ {
+		colPixels, err := columnPixels(f, sheetName, col)
+		if err != nil {
+			return 0, 0, err
+		}
+		width -= colPixels
+	}
+	for ; height > 0; row++ {
+		rowHeight, err := f.GetRowHeight(sheetName, row)
+		if err != nil {
+			return 0, 0, err
+		}
+		height -= rowHeight / 0.75
+	}
+	return col - 1, row - 1, nil
+}
+
+// charsToPixels converts a column width to pixels. Excel measures column
+// widths in characters of a 7 pixel digit, plus 5 pixels of padding.
+func charsToPixels(chars float64) float64 {
+	return math.Round(chars*7 + 5)
+}
+
+// pixelsToChars converts a width in pixels to the column width that shows it,
+// the inverse of charsToPixels
+func pixelsToChars(pixels float64) float64 {
+	return max((pixels-5)/7, 0)
+}
+
+// columnPixels returns the width of the column in pixels, as Excel shows it
+func columnPixels(f *excelize.File, sheetName string, col int) (float64, error) {
+	colName, err := excelize.ColumnNumberToName(col)
+	if err != nil {
+		return 0, err
+	}
+	colWidth, err := f.GetColWidth(sheetName, colName)
+	if err != nil {
+		return 0, err
+	}
+	return charsToPixels(colWidth), nil
+}
+
+// setPrintArea sets the sheet's print area from A1 to the bottom-right
+// corner of the area, replacing any print area of the template
+func setPrintArea(f *excelize.File, sheetName string, a *printArea) error {
+	if a.lastCol == 0 || a.lastRow == 0 {
+		return nil
+	}
+	lastCell, err := excelize.CoordinatesToCellName(a.lastCol, a.lastRow, true)
+	if err != nil {
+		return err
+	}
+	name := &excelize.DefinedName{
+		Name:     "_xlnm.Print_Area",
+		RefersTo: "'" + strings.ReplaceAll(sheetName, "'", "''") + "'!$A$1:" + lastCell,
+		Scope:    sheetName,
+	}
+	// Ignore the error when the template has no print area to delete
+	_ = f.DeleteDefinedName(name)
+	if err := f.SetDefinedName(name); err != nil {
+		return fmt.Errorf("failed to set print area %s: %w", name.RefersTo, err)
+	}
+	return nil
+}
+
+// setFooter sets the sheet's printed footer from the left, center and right
+// footer options, appending "&P of &N" to the center with opts.PageNumbers.
+// The template's headers are kept, as are its footers on the first and even
+// pages.
+func setFooter(f *excelize.File, sheetName string, opts Options) error {
+	center := opts.FooterCenter
+	if opts.PageNumbers {
+		center = strings.TrimSpace(center + " &P of &N")
+	}
+	var footer string
+	for _, section := range []struct{ code, text string }{{"&L", opts.FooterLeft}, {"&C", center}, {"&R", opts.FooterRight}} {
+		if section.text != "" {
+			footer += section.code + section.text
+		}
+	}
+	if footer == "" {
+		return nil
+	}
+	hf, err := f.GetHeaderFooter(sheetName)
+	if err != nil {
+		return err
+	}
+	if hf == nil {
+		hf = &excelize.HeaderFooterOptions{}
+	}
+	hf.OddFooter = footer
+	if err := f.SetHeaderFooter(sheetName, hf); err != nil {
+		return fmt.Errorf("failed to set footer %q: %w", footer, err)
+	}
+	return nil
+}
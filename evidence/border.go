@@ -0,0 +1,86 @@
+package evidence
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// borderStyles maps the accepted border widths to excelize border styles
+var borderStyles = map[string]int{"thin": 1, "medium": 2, "thick": 5}
+
+// hexColor matches the RGB colors accepted for borders and backgrounds, with
+// or without a #
+var hexColor = regexp.MustCompile(`^#?[0-9A-Fa-f]{6}$`)
+
+// addBorder draws a frame around the cells covered by an image of the given
+// size in pixels anchored at the cell. Each cell on the edge gets only the
+// sides facing outwards, so no lines are drawn across the image, on top of the
+// style the cell already has.
+func addBorder(f *excelize.File, sheetName, cellName string, width, height float64, opts Options) error {
+	firstCol, firstRow, err := excelize.CellNameToCoordinates(cellName)
+	if err != nil {
+		return err
+	}
+	lastCol, lastRow, err := imageExtent(f, sheetName, cellName, width, height)
+	if err != nil {
+		return err
+	}
+
+	color := strings.TrimPrefix(opts.BorderColor, "#")
+	for row := firstRow; row <= lastRow; row++ {
+		for col := firstCol; col <= lastCol; col++ {
+			var sides []excelize.Border
+			add := func(side string, edge bool) {
+				if edge {
+					sides = append(sides, excelize.Border{Type: side, Color: color, Style: borderStyles[opts.BorderWidth]})
+				}
+			}
+			add("left", col == firstCol)
+			add("right", col == lastCol)
+			add("top", row == firstRow)
+			add("bottom", row == lastRow)
+			if len(sides) == 0 {
+				continue
+			}
+			cell, _ := excelize.CoordinatesToCellName(col, row)
+			if err := addCellBorder(f, sheetName, cell, sides); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addCellBorder adds the border sides to the cell's current style, replacing
+// its own border on those sides and keeping its fill, font, number format and
+// alignment
+func addCellBorder(f *excelize.File, sheetName, cell string, sides []excelize.Border) error {
+	id, err := f.GetCellStyle(sheetName, cell)
+	if err != nil {
+		return fmt.Errorf("failed to read style at %s: %w", cell, err)
+	}
+	style, err := f.GetStyle(id)
+	if err != nil {
+		return fmt.Errorf("failed to read style at %s: %w", cell, err)
+	}
+	borders := sides
+	for _, border := range style.Border {
+		if !slices.ContainsFunc(sides, func(side excelize.Border) bool { return side.Type == border.Type }) {
+			borders = append(borders, border)
+		}
+	}
+	style.Border = borders
+	// excelize reuses an existing style with the same definition
+	newID, err := f.NewStyle(style)
+	if err != nil {
+		return fmt.Errorf("failed to create border style: %w", err)
+	}
+	if err := f.SetCellStyle(sheetName, cell, cell, newID); err != nil {
+		return fmt.Errorf("failed to set border at %s: %w", cell, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package evidence
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Check validates the template without inserting anything: it opens the
+// workbook, prints its sheets with their used ranges and checks that each
+// target sheet exists, or would be created, and that the start cell resolves
+// on it. The image folders are not read and nothing is saved.
+func Check(opts Options) error {
+	if opts.TemplatePath == "" {
+		return fmt.Errorf("Please provide the excel file path using the -excel flag.")
+	}
+	f, err := openExcelFile(opts.TemplatePath, opts.Password)
+	if err != nil {
+		return fmt.Errorf("Failed to open template file: %w", err)
+	}
+	defer f.Close()
+
+	out := opts.output()
+	sheets := f.GetSheetList()
+	fmt.Fprintf(out, "%s has %d sheets:\n", opts.TemplatePath, len(sheets))
+	for i, sheet := range sheets {
+		dimension, err := f.GetSheetDimension(sheet)
+		if err != nil {
+			return fmt.Errorf("Failed to read sheet %q: %w", sheet, err)
+		}
+		if dimension == "" {
+			dimension = "empty"
+		}
+		fmt.Fprintf(out, "  %d\t%s\t%s\n", i, sheet, dimension)
+	}
+
+	if opts.SheetName, err = sheetAtIndex(f, opts); err != nil {
+		return err
+	}
+	if opts.TemplateSheet != "" && !slices.Contains(sheets, opts.TemplateSheet) {
+		return errorf(ErrSheetNotFound, "The template sheet %q does not exist in the workbook.", opts.TemplateSheet)
+	}
+	for _, target := range opts.targets() {
+		if target.SheetName == "" {
+			continue
+		}
+		sheetName := sanitizeSheetName(target.SheetName, opts)
+		if !slices.Contains(sheets, sheetName) {
+			if !opts.CreateSheet && opts.TemplateSheet == "" {
+				return errorf(ErrSheetNotFound, "The sheet %q does not exist in the workbook.", sheetName)
+			}
+			fmt.Fprintf(out, "Sheet %s will be created\n", sheetName)
+		}
+		// New sheets are copies of the template sheet, or blank
+		resolveOn := sheetName
+		if !slices.Contains(sheets, sheetName) {
+			resolveOn = opts.TemplateSheet
+		}
+		startCell, err := resolveStartCell(f, resolveOn, opts.TemplateSheet, opts.StartCell)
+		if err != nil {
+			return fmt.Errorf("Invalid start cell %q on sheet %s: %w", opts.StartCell, sheetName, err)
+		}
+		fmt.Fprintf(out, "Sheet %s: images start at %s\n", sheetName, startCell)
+	}
+	fmt.Fprintln(out, "Template OK")
+	return nil
+}
@@ -0,0 +1,61 @@
+package evidence
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// contentsSheet is the name of the sheet the table of contents is written to
+const contentsSheet = "Contents"
+
+// contentsEntry records where an image was placed
+type contentsEntry struct {
+	Name      string
+	SheetName string
+	Cell      string
+}
+
+// contents collects the placed images for the table of contents
+type contents struct {
+	entries []contentsEntry
+}
+
+// add records an image placed at the cell, if the contents are collected
+func (c *contents) add(img ImageInfo, sheetName, cell string) {
+	if c == nil {
+		return
+	}
+	c.entries = append(c.entries, contentsEntry{Name: captionText(img), SheetName: sheetName, Cell: cell})
+}
+
+// writeContents writes a row per image into the Contents sheet, creating it
+// when needed, with a hyperlink from the image name to its cell
+func writeContents(f *excelize.File, c *contents) error {
+	index, err := f.GetSheetIndex(contentsSheet)
+	if err != nil {
+		return err
+	}
+	if index < 0 {
+		if _, err := f.NewSheet(contentsSheet); err != nil {
+			return fmt.Errorf("failed to create sheet %s: %w", contentsSheet, err)
+		}
+	}
+
+	if err := f.SetSheetRow(contentsSheet, "A1", &[]any{"Image", "Sheet", "Cell"}); err != nil {
+		return err
+	}
+	for i, entry := range c.entries {
+		row := i + 2
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		if err := f.SetSheetRow(contentsSheet, cell, &[]any{entry.Name, entry.SheetName, entry.Cell}); err != nil {
+			return err
+		}
+		location := "'" + strings.ReplaceAll(entry.SheetName, "'", "''") + "'!" + entry.Cell
+		if err := f.SetCellHyperLink(contentsSheet, cell, location, "Location"); err != nil {
+			return fmt.Errorf("failed to link %s to %s: %w", cell, location, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package evidence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// addCover copies the first sheet of the cover workbook into f as its first
+// and active sheet: cell values, styles, merged cells, column widths, row
+// heights and pictures. The {{DATE}}, {{COUNT}} and {{TITLE}} placeholders in
+// its text cells are replaced by today's date, the number of inserted images
+// and the sheet title.
+func addCover(f *excelize.File, coverPath string, count int, title string) error {
+	cover, err := excelize.OpenFile(coverPath)
+	if err != nil {
+		return fmt.Errorf("failed to open cover file: %w", err)
+	}
+	defer cover.Close()
+
+	sheet := cover.GetSheetList()[0]
+	if index, err := f.GetSheetIndex(sheet); err != nil {
+		return err
+	} else if index >= 0 {
+		return fmt.Errorf("the workbook already has a sheet named %s", sheet)
+	}
+	if _, err := f.NewSheet(sheet); err != nil {
+		return fmt.Errorf("failed to create sheet %s: %w", sheet, err)
+	}
+
+	placeholders := strings.NewReplacer(
+		"{{DATE}}", time.Now().Format("2006-01-02"),
+		"{{COUNT}}", strconv.Itoa(count),
+		"{{TITLE}}", title,
+	)
+	if err := copyCells(cover, f, sheet, placeholders); err != nil {
+		return err
+	}
+
+	merges, err := cover.GetMergeCells(sheet)
+	if err != nil {
+		return err
+	}
+	for _, merge := range merges {
+		if err := f.MergeCell(sheet, merge.GetStartAxis(), merge.GetEndAxis()); err != nil {
+			return err
+		}
+	}
+
+	cells, err := cover.GetPictureCells(sheet)
+	if err != nil {
+		return err
+	}
+	for _, cell := range cells {
+		pictures, err := cover.GetPictures(sheet, cell)
+		if err != nil {
+			return err
+		}
+		for _, picture := range pictures {
+			if err := f.AddPictureFromBytes(sheet, cell, &picture); err != nil {
+				return fmt.Errorf("failed to copy picture at %s: %w", cell, err)
+			}
+		}
+	}
+
+	if err := f.MoveSheet(sheet, f.GetSheetList()[0]); err != nil {
+		return err
+	}
+	f.SetActiveSheet(0)
+	return nil
+}
+
+// copyCells copies the values, formulas and styles of the cells in the used
+// range of the sheet, and the widths and heights of its columns and rows,
+// replacing the placeholders in text values
+func copyCells(src, dst *excelize.File, sheet string, placeholders *strings.Replacer) error {
+	// The stored dimension is not always kept up to date, so the range also
+	// covers every row read back
+	lastCol, lastRow := 0, 0
+	dimension, err := src.GetSheetDimension(sheet)
+	if err != nil {
+		return err
+	}
+	if dimension != "" {
+		// A single cell range has no end cell
+		lastCell := dimension[strings.LastIndex(dimension, ":")+1:]
+		if lastCol, lastRow, err = excelize.CellNameToCoordinates(lastCell); err != nil {
+			return err
+		}
+	}
+	rows, err := src.GetRows(sheet)
+	if err != nil {
+		return err
+	}
+	lastRow = max(lastRow, len(rows))
+	for _, row := range rows {
+		lastCol = max(lastCol, len(row))
+	}
+
+	styles := map[int]int{}
+	for row := 1; row <= lastRow; row++ {
+		height, err := src.GetRowHeight(sheet, row)
+		if err != nil {
+			return err
+		}
+		if err := dst.SetRowHeight(sheet, row, height); err != nil {
+			return err
+		}
+		for col := 1; col <= lastCol; col++ {
+			cell, _ := excelize.CoordinatesToCellName(col, row)
+			if err := copyCell(src, dst, sheet, cell, styles, placeholders); err != nil {
+				return fmt.Errorf("failed to copy cell %s: %w", cell, err)
+			}
+		}
+	}
+	for col := 1; col <= lastCol; col++ {
+		name, _ := excelize.ColumnNumberToName(col)
+		width, err := src.GetColWidth(sheet, name)
+		if err != nil {
+			return err
+		}
+		if err := dst.SetColWidth(sheet, name, name, width); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyCell copies one cell, creating each source style in dst once
+func copyCell(src, dst *excelize.File, sheet, cell string, styles map[int]int, placeholders *strings.Replacer) error {
+	if formula, err := src.GetCellFormula(sheet, cell); err != nil {
+		return err
+	} else if formula != "" {
+		if err := dst.SetCellFormula(sheet, cell, formula); err != nil {
+			return err
+		}
+	} else {
+		value, err := src.GetCellValue(sheet, cell, excelize.Options{RawCellValue: true})
+		if err != nil {
+			return err
+		}
+		cellType, err := src.GetCellType(sheet, cell)
+		if err != nil {
+			return err
+		}
+		// Plain numbers are stored without a type
+		number, numErr := strconv.ParseFloat(value, 64)
+		switch {
+		case value == "":
+		case cellType == excelize.CellTypeNumber || cellType == excelize.CellTypeDate ||
+			cellType == excelize.CellTypeUnset && numErr == nil:
+			if numErr != nil {
+				return numErr
+			}
+			if err := dst.SetCellValue(sheet, cell, number); err != nil {
+				return err
+			}
+		case cellType == excelize.CellTypeBool:
+			if err := dst.SetCellBool(sheet, cell, value == "1"); err != nil {
+				return err
+			}
+		default:
+			if err := dst.SetCellStr(sheet, cell, placeholders.Replace(value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	id, err := src.GetCellStyle(sheet, cell)
+	if err != nil || id == 0 {
+		return err
+	}
+	if _, ok := styles[id]; !ok {
+		style, err := src.GetStyle(id)
+		if err != nil {
+			return err
+		}
+		if styles[id], err = dst.NewStyle(style); err != nil {
+			return err
+		}
+	}
+	return dst.SetCellStyle(sheet, cell, cell, styles[id])
+}
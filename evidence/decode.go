@@ -0,0 +1,474 @@
+package evidence
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// rotations maps the clockwise angles accepted for Options.Rotate to the EXIF
+// orientations that apply them
+var rotations = map[int]int{90: 6, 180: 3, 270: 8}
+
+// loadImages reads and decodes the images using up to opts.Jobs concurrent
+// workers, storing each image's bytes and dimensions on its ImageInfo. The
+// error for the earliest failing image in the list is returned, unless
+// failures are being collected, in which case the failing images are recorded
+// and left out of the returned list.
+func loadImages(images []ImageInfo, opts Options) ([]ImageInfo, error) {
+	errs := make([]error, len(images))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(opts.Jobs, len(images)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = loadImage(&images[i], opts)
+			}
+		}()
+	}
+	for i := range images {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	for _, img := range images {
+		img.printWarning(opts)
+	}
+	if opts.failures == nil {
+		if err := firstError(errs); err != nil {
+			return nil, err
+		}
+	} else {
+		loaded := images[:0]
+		for i, img := range images {
+			if errs[i] != nil {
+				opts.failures.add(img, errs[i], opts)
+				continue
+			}
+			loaded = append(loaded, img)
+		}
+		images = loaded
+	}
+	if opts.Quality > 0 {
+		opts.logf("Recompression saved %d bytes", bytesSaved(images))
+	}
+	return images, nil
+}
+
+// printWarning prints the warning loadImage left about the image, if any
+func (img ImageInfo) printWarning(opts Options) {
+	if img.warning != "" {
+		fmt.Fprintln(opts.warnings(), img.warning)
+	}
+}
+
+// bytesSaved returns how much smaller the embedded bytes are than the
+// original files
+func bytesSaved(images []ImageInfo) int64 {
+	var saved int64
+	for _, img := range images {
+		if img.Info != nil {
+			saved += img.Info.Size() - int64(len(img.Data))
+		}
+	}
+	return saved
+}
+
+// firstError returns the first non-nil error in errs
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadImage reads a single image file once, decodes its dimensions and format
+// from the same bytes and prepares them for embedding
+func loadImage(img *ImageInfo, opts Options) error {
+	opts.logf("Reading %s", img.FilePath)
+	data := img.Source
+	if data == nil {
+		r, err := openImage(*img, opts)
+		if err != nil {
+			return err
+		}
+		data, err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read image %s: %w", img.FilePath, err)
+		}
+	}
+	if opts.Dedupe {
+		img.sum = sha256.Sum256(data)
+	}
+	if strings.EqualFold(filepath.Ext(img.FilePath), ".svg") {
+		var err error
+		if data, err = rasterizeSVG(data, opts); err != nil {
+			return fmt.Errorf("%s: %w", img.FilePath, err)
+		}
+	}
+	width, height, format, err := getDimensions(data)
+	if errors.Is(err, image.ErrFormat) {
+		return errorf(ErrUnsupportedFormat, "failed to get image dimensions: %s: %w", img.FilePath, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to get image dimensions: %s: %w", img.FilePath, err)
+	}
+	if err := checkDimensions(width, height); err != nil {
+		return fmt.Errorf("%s: %w", img.FilePath, err)
+	}
+	if opts.WarnPixels > 0 && width*height > opts.WarnPixels {
+		// Printed by the caller, as images are loaded concurrently
+		img.warning = fmt.Sprintf("Warning: %s is %dx%d pixels, which may make the workbook slow to open", img.FilePath, width, height)
+	}
+	if format == "gif" {
+		if data, err = gifFrame(data, opts.GIFFrame, opts); err != nil {
+			return fmt.Errorf("%s: %w", img.FilePath, err)
+		}
+		format = "png"
+	}
+	if opts.AutoRotate {
+		if orientation := exifOrientation(data); orientation > 1 {
+			opts.logf("Applying EXIF orientation %d to %s", orientation, img.FilePath)
+			if data, err = orientImage(data, orientation); err != nil {
+				return fmt.Errorf("%s: %w", img.FilePath, err)
+			}
+			if width, height, format, err = getDimensions(data); err != nil {
+				return fmt.Errorf("%s: %w", img.FilePath, err)
+			}
+		}
+	}
+	if opts.Rotate != 0 {
+		opts.logf("Rotating %s by %d degrees", img.FilePath, opts.Rotate)
+		if data, err = orientImage(data, rotations[opts.Rotate]); err != nil {
+			return fmt.Errorf("%s: %w", img.FilePath, err)
+		}
+		if width, height, format, err = getDimensions(data); err != nil {
+			return fmt.Errorf("%s: %w", img.FilePath, err)
+		}
+	}
+	if opts.MaxDim > 0 && max(width, height) > opts.MaxDim {
+		opts.logf("Downscaling %s from %dx%d to fit %d pixels", img.FilePath, width, height, opts.MaxDim)
+		if data, err = downscaleImage(data, opts.MaxDim); err != nil {
+			return fmt.Errorf("%s: %w", img.FilePath, err)
+		}
+		if width, height, format, err = getDimensions(data); err != nil {
+			return fmt.Errorf("%s: %w", img.FilePath, err)
+		}
+	}
+	opts.logf("Dimensions of %s: %dx%d", img.FilePath, width, height)
+	if opts.FlattenBG != "" && format != "jpeg" && format != "bmp" {
+		flat, err := flattenImage(data, opts.FlattenBG)
+		if err != nil {
+			return fmt.Errorf("%s: %w", img.FilePath, err)
+		}
+		if flat != nil {
+			opts.logf("Flattened transparency of %s onto #%s", img.FilePath, strings.TrimPrefix(opts.FlattenBG, "#"))
+			data, format = flat, "png"
+		}
+	}
+	data, extension, err := prepareImage(data, format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", img.FilePath, err)
+	}
+	if opts.Quality > 0 {
+		jpegBytes, err := convertToJPEG(data, opts.Quality)
+		if err != nil {
+			return fmt.Errorf("%s: %w", img.FilePath, err)
+		}
+		// Keep the original when re-encoding does not make it smaller
+		if len(jpegBytes) < len(data) {
+			opts.logf("Recompressed %s from %d to %d bytes", img.FilePath, len(data), len(jpegBytes))
+			data, extension = jpegBytes, ".jpg"
+		}
+	}
+	img.Data, img.Extension, img.Width, img.Height = data, extension, width, height
+	return nil
+}
+
+// exifOrientation returns the EXIF orientation tag of the image, or 0 when it
+// has none
+func exifOrientation(imgBytes []byte) int {
+	x, err := exif.Decode(bytes.NewReader(imgBytes))
+	if err != nil {
+		return 0
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 0
+	}
+	orientation, err := tag.Int(0)
+	if err != nil {
+		return 0
+	}
+	return orientation
+}
+
+// orientImage decodes the image, transforms it so that it displays upright
+// for the given EXIF orientation and re-encodes it as PNG
+func orientImage(imgBytes []byte, orientation int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(imgBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Orientations 5 to 8 swap the width and height
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Flip horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // Flip vertically
+				dx, dy = x, h-1-y
+			case 5: // Transpose
+				dx, dy = y, x
+			case 6: // Rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // Transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotate 90 counter-clockwise
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("failed to encode rotated image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// getDimensions decodes the image header to get its dimensions and format
+// without decoding the pixel data
+func getDimensions(imgBytes []byte) (int, int, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(imgBytes))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return config.Width, config.Height, format, nil
+}
+
+// checkDimensions rejects images that decode to no pixels, which a corrupt
+// capture can do and which would leave nothing to scale
+func checkDimensions(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid image dimensions %dx%d", width, height)
+	}
+	return nil
+}
+
+// prepareImage returns bytes excelize can embed for an image of the given
+// format along with the matching extension. Formats excelize does not
+// support, such as WebP and TIFF, are converted to PNG. Only the first page
+// of a multi-page TIFF is decoded.
+func prepareImage(imgBytes []byte, format string) ([]byte, string, error) {
+	switch format {
+	case "png":
+		return imgBytes, ".png", nil
+	case "jpeg":
+		return imgBytes, ".jpg", nil
+	case "bmp":
+		return imgBytes, ".bmp", nil
+	case "webp", "tiff":
+		pngBytes, err := convertToPNG(imgBytes)
+		if err != nil {
+			return nil, "", err
+		}
+		return pngBytes, ".png", nil
+	}
+	return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+}
+
+// downscaleImage shrinks an image so its longest side is maxDim pixels,
+// keeping the aspect ratio, and returns it encoded as PNG
+func downscaleImage(imgBytes []byte, maxDim int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(imgBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	bounds := img.Bounds()
+	scale := float64(maxDim) / float64(max(bounds.Dx(), bounds.Dy()))
+	width := max(int(math.Round(float64(bounds.Dx())*scale)), 1)
+	height := max(int(math.Round(float64(bounds.Dy())*scale)), 1)
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, resized); err != nil {
+		return nil, fmt.Errorf("failed to encode downscaled image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// gifFrame returns the frame at index of a GIF encoded as PNG. Frames are
+// composed in order, so frames that only update part of the image come out
+// whole. An index past the last frame selects the last one.
+func gifFrame(imgBytes []byte, index int, opts Options) ([]byte, error) {
+	anim, err := gif.DecodeAll(bytes.NewReader(imgBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode GIF: %w", err)
+	}
+	if len(anim.Image) == 0 {
+		return nil, fmt.Errorf("GIF has no frames")
+	}
+	if index >= len(anim.Image) {
+		opts.logf("GIF has %d frames, using the last one instead of frame %d", len(anim.Image), index)
+		index = len(anim.Image) - 1
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+	for i, frame := range anim.Image[:index+1] {
+		var previous *image.RGBA
+		if i < len(anim.Disposal) && anim.Disposal[i] == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			draw.Draw(previous, previous.Bounds(), canvas, image.Point{}, draw.Src)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i == index {
+			break
+		}
+		// Undo the frame before drawing the next one as the GIF asks
+		if i < len(anim.Disposal) {
+			switch anim.Disposal[i] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, fmt.Errorf("failed to convert GIF frame to PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// rasterizeSVG renders an SVG as PNG, fitting its view box within the
+// display size of opts.Width x opts.Height at opts.SVGDPI, where 96 DPI gives
+// one image pixel per display pixel
+func rasterizeSVG(svgBytes []byte, opts Options) ([]byte, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(svgBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SVG: %w", err)
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, fmt.Errorf("SVG has no size")
+	}
+	scale := min(opts.Width/icon.ViewBox.W, opts.Height/icon.ViewBox.H) * float64(opts.SVGDPI) / 96
+	width := max(int(math.Round(icon.ViewBox.W*scale)), 1)
+	height := max(int(math.Round(icon.ViewBox.H*scale)), 1)
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, canvas, canvas.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, fmt.Errorf("failed to convert SVG to PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// flattenImage composites an image with transparent pixels onto a solid
+// background of the given hex RGB color and re-encodes it as PNG. It returns
+// nil for fully opaque images, which are embedded unchanged.
+func flattenImage(imgBytes []byte, background string) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(imgBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return nil, nil
+	}
+	bg, err := parseHexColor(background)
+	if err != nil {
+		return nil, err
+	}
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, flat); err != nil {
+		return nil, fmt.Errorf("failed to encode flattened image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseHexColor parses an RRGGBB color, with or without a leading #
+func parseHexColor(value string) (color.RGBA, error) {
+	if !hexColor.MatchString(value) {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", value)
+	}
+	rgb, _ := strconv.ParseUint(strings.TrimPrefix(value, "#"), 16, 32)
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// convertToJPEG decodes an image and re-encodes it as JPEG at the given
+// quality. Transparent areas are flattened onto white, as JPEG has no alpha.
+func convertToJPEG(imgBytes []byte, quality int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(imgBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to convert image to JPEG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// convertToPNG decodes an image and re-encodes it as PNG
+func convertToPNG(imgBytes []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(imgBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to convert image to PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
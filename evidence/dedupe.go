@@ -0,0 +1,64 @@
+package evidence
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// duplicates remembers the SHA-256 hash of every image inserted so far, so
+// identical captures are only inserted once
+type duplicates struct {
+	seen map[[sha256.Size]byte]string
+}
+
+// newDuplicates returns an empty set of seen images
+func newDuplicates() *duplicates {
+	return &duplicates{seen: map[[sha256.Size]byte]string{}}
+}
+
+// filter drops the images whose bytes match an image seen before, earlier in
+// the list or in an earlier sheet. Images not loaded yet, as in stream mode,
+// are hashed straight from their file.
+func (d *duplicates) filter(images []ImageInfo, opts Options) ([]ImageInfo, error) {
+	if d == nil {
+		return images, nil
+	}
+	kept := images[:0]
+	for _, img := range images {
+		if img.Data == nil {
+			var err error
+			if img.sum, err = hashImage(img, opts); err != nil {
+				if opts.failures == nil {
+					return nil, fmt.Errorf("%s: %w", img.FilePath, err)
+				}
+				opts.failures.add(img, err, opts)
+				continue
+			}
+		}
+		if original, ok := d.seen[img.sum]; ok {
+			fmt.Fprintf(opts.warnings(), "Skipping %s, identical to %s\n", img.FilePath, original)
+			continue
+		}
+		d.seen[img.sum] = img.FilePath
+		kept = append(kept, img)
+	}
+	return kept, nil
+}
+
+// hashImage returns the SHA-256 hash of the image's original bytes without
+// holding the whole file in memory
+func hashImage(img ImageInfo, opts Options) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	r, err := openImage(img, opts)
+	if err != nil {
+		return sum, err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return sum, fmt.Errorf("failed to read image file: %w", err)
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,33 @@
+package evidence
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors callers can test for with errors.Is. Insert's errors keep their
+// messages and wrap one of these when they fall into one of the kinds.
+var (
+	ErrSheetNotFound     = errors.New("sheet not found")
+	ErrNoImages          = errors.New("no images found")
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrFileLocked        = errors.New("file is open in another program")
+	ErrPasswordProtected = errors.New("workbook is password protected")
+	ErrSkipped           = errors.New("images were skipped, the rest were saved")
+)
+
+// kindError is an error with its own message that also matches a sentinel
+// error kind
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// errorf formats an error like fmt.Errorf, including any %w causes, and marks
+// it as being of the given kind
+func errorf(kind error, format string, args ...any) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
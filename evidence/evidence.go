@@ -0,0 +1,1042 @@
+// Package evidence inserts folders of test screenshots into the sheets of an
+// Excel template, scaling and laying them out for printing.
+package evidence
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	defaultWidth  = 1115.9 // Default desired width in pixels
+	defaultHeight = 609.2  // Default desired height in pixels
+
+	defaultRowHeight = 20.0 // Height of a default worksheet row in pixels
+	defaultColWidth  = 8.43 // Excel's standard column width in characters
+
+	pixelsPerCM        = 96 / 2.54 // Excel sizes pictures at 96 pixels per inch
+	maxSheetNameLength = 31        // Longest sheet name Excel accepts, in characters
+)
+
+// SheetTarget pairs a sheet with the folder whose images are inserted into it
+type SheetTarget struct {
+	SheetName  string
+	FolderPath string
+}
+
+// Placement describes where an image was inserted
+type Placement struct {
+	Path   string  `json:"path"`    // Source file of the image
+	Sheet  string  `json:"sheet"`   // Sheet the image was inserted into
+	Cell   string  `json:"cell"`    // Cell the image is anchored at
+	ScaleX float64 `json:"scale_x"` // Horizontal scale factor
+	ScaleY float64 `json:"scale_y"` // Vertical scale factor
+}
+
+// Skip describes an image left out because it could not be read or inserted
+type Skip struct {
+	Path  string `json:"path"`  // Source file of the image
+	Error string `json:"error"` // Why the image was skipped
+}
+
+// Options holds the settings of an Insert run. They mirror the command-line
+// flags; DefaultOptions returns the flag defaults.
+type Options struct {
+	FolderPath    string        // Folder containing the images
+	SheetName     string        // Sheet the images are inserted into
+	SheetIndex    int           // Zero-based position of the sheet to use instead of SheetName, -1 for none
+	Sheets        []SheetTarget // Several sheets to populate, replaces FolderPath and SheetName
+	TemplatePath  string        // Excel template file
+	OutPath       string        // File the workbook is saved to, the template itself when empty
+	Password      string        // Password of an encrypted template, which the saved workbook is encrypted with too
+	CreateSheet   bool          // Create target sheets that do not exist
+	MaxPerSheet   int           // Images per sheet before continuing on a copy named <sheet>_2 and so on, 0 for no limit
+	TemplateSheet string        // Sheet copied into each new target sheet before the images are inserted
+	SplitByFolder bool          // Insert each immediate subfolder into its own sheet named after it
+	SplitFiles    bool          // With SplitByFolder, write each subfolder to its own workbook in the OutPath directory
+
+	// Which image files are collected and how they are ordered
+	Recursive     bool      // Descend into subfolders
+	SortBy        string    // name, natural, mtime or size
+	Reverse       bool      // Invert the sort order
+	Extensions    []string  // Accepted lower-case file extensions, including the dot
+	Glob          string    // Pattern the relative path must match, empty for all files
+	Since         time.Time // Only include files modified at or after this time, zero for no lower bound
+	Until         time.Time // Only include files modified at or before this time, zero for no upper bound
+	Manifest      string    // CSV listing the images to include instead of walking the folder
+	PlacementFile string    // CSV of cell,filename pairs placing each image at its own cell
+	Skip          int       // Number of images to drop from the start of the list
+	Limit         int       // Maximum number of images to include, 0 for no limit
+	Dedupe        bool      // Skip images whose bytes are identical to an image already inserted
+	MaxFileSize   float64   // Size in megabytes above which image files are skipped, 0 for no limit
+	FailFast      bool      // Stop at empty or oversized image files instead of skipping them with a warning
+
+	// How image files are read and transformed before they are embedded
+	Jobs       int           // Number of images read and decoded concurrently
+	Timeout    time.Duration // Time limit for downloading each image listed as an http(s) URL, 0 for none
+	AutoRotate bool          // Apply the EXIF orientation to the pixel data
+	Rotate     int           // Clockwise angle every image is rotated by after AutoRotate: 0, 90, 180 or 270
+	Quality    int           // Re-encode images as JPEG at this quality (1-100), 0 to embed them unchanged
+	MaxDim     int           // Downscale images whose longest side exceeds this many pixels, 0 for no limit
+	WarnPixels int           // Warn about images with more pixels than this, 0 to never warn
+	GIFFrame   int           // Index of the frame inserted from animated GIFs
+	FlattenBG  string        // Hex RGB color transparent images are composited onto, empty to keep their transparency
+	SVGDPI     int           // Resolution SVG images are rasterized at, 96 for one pixel per display pixel
+	Stream     bool          // Read each image only when it is placed instead of all of them up front
+
+	// Where and how large images are placed in the sheet
+	StartCell           string  // Cell, or defined name of a cell, where the first image is inserted
+	Append              bool    // Continue the layout after the pictures already in the sheet instead of at StartCell
+	Layout              string  // horizontal, vertical or grid
+	Width               float64 // Desired image width in pixels
+	Height              float64 // Desired image height in pixels
+	WidthCM             float64 // Printed image width in centimeters, replacing Width when set
+	HeightCM            float64 // Printed image height in centimeters, replacing Height when set
+	ColStep             int     // Columns between images (horizontal) or per page (grid), 0 for auto
+	Spacing             float64 // Advance each image in horizontal layout by its scaled width times this, 0 to use ColStep
+	ColumnsFromTemplate bool    // In horizontal layout, place one image per template column, fitted to its width
+	Table               string  // Excel table whose data rows each receive one image, in the start cell column
+	RowStep             int     // Rows between images (vertical) or per page (grid)
+	Cols                int     // Image columns per page in grid layout
+	Rows                int     // Image rows per page in grid layout
+	CenterLastRow       bool    // Center the images of a partly filled last grid row
+
+	GroupByPrefix   bool   // Separate groups of images sharing a file name prefix
+	GroupDelimiters string // Characters ending the group prefix, besides digits
+	GroupGap        int    // Extra columns (horizontal) or rows (vertical) between groups
+
+	PageBreak  string // Page break mode: each, none or every-n
+	BreakEvery int    // Images, or grid pages, per break in every-n mode
+	BreakRow   int    // Row of the page breaks in horizontal layout
+
+	PreserveAspect  bool   // Scale uniformly to fit within Width x Height
+	DryRun          bool   // Print the planned placement instead of inserting
+	FitCells        bool   // Resize each image's column and row to the image size
+	AutoFit         bool   // Let excelize shrink each image to fit its cell, or merged cell, instead of scaling it
+	Caption         bool   // Write the image file name below each image
+	CaptionOffset   int    // Rows between the image cell and its caption, 0 for directly below
+	CaptionPosition string // below, or above to reserve the offset rows above each image for its caption
+
+	Border      bool   // Frame the cells covered by each image with a border
+	BorderColor string // Hex RGB color of the border
+	BorderWidth string // thin, medium or thick
+
+	ContinueOnError bool // Skip images that cannot be read or inserted, failing at the end
+	Retry           int  // Times saving is retried while the output file is open in another program
+
+	TimestampCaption bool   // Write the image's modification time in its caption cell
+	TimeFormat       string // Go reference time layout of the timestamp captions
+
+	Positioning string // Image anchoring: oneCell, twoCell, absolute, or empty for the excelize default
+	OffsetX     int    // Pixels between the left edge of the cell and the image
+	OffsetY     int    // Pixels between the top edge of the cell and the image
+
+	Link        bool   // Set a hyperlink to the source file on each image's cell
+	LinkBase    string // URL the relative image paths are linked under instead of the local file
+	CellComment bool   // Attach a comment with the file name, dimensions and caption to each image's cell
+
+	Meta         bool   // Write the run metadata above the images, which start below it
+	TOC          bool   // Write a Contents sheet linking to each image
+	Cover        string // Workbook whose first sheet is copied in as the first sheet, with its placeholders filled in
+	PrintArea    bool   // Set each sheet's print area to cover the inserted images
+	PageNumbers  bool   // Number the printed pages as "N of M" in the center of the footer
+	FooterLeft   string // Left section of the printed footer, may use Excel codes such as &D
+	FooterCenter string // Center section of the printed footer
+	FooterRight  string // Right section of the printed footer
+	Title        string // Title written once per sheet, empty for none
+	TitleCell    string // Cell the title is written to
+	CountCell    string // Cell, or defined name of a cell, the number of images inserted into the sheet is written to
+
+	Logger   *log.Logger     // Receives detailed progress messages, nil to discard them
+	Output   io.Writer       // Receives the dry run plan, os.Stdout when nil
+	Warnings io.Writer       // Receives warnings, such as about skipped images, os.Stderr when nil
+	Input    io.Reader       // Image paths read for the StdinFolder, os.Stdin when nil
+	OnPlace  func(Placement) // Called for each image inserted, or planned in a dry run
+	OnSkip   func(Skip)      // Called for each image skipped with ContinueOnError
+	Progress io.Writer       // Receives an N/total count as images are inserted, nil for none
+
+	progress   *progress    // Counts the images inserted into the current sheet
+	contents   *contents    // Collects the placed images for the table of contents
+	area       *printArea   // Tracks the content of the current sheet for its print area
+	placed     *placedWidth // Width of the last image placed when Spacing is set
+	failures   *failures    // Collects the skipped images when ContinueOnError is set
+	duplicates *duplicates  // Hashes of the inserted images when Dedupe is set
+	writer     io.Writer    // Receives the workbook instead of saving it, set by InsertTo
+}
+
+// DefaultOptions returns the options used when no flags are given
+func DefaultOptions() Options {
+	return Options{
+		SheetIndex:      -1,
+		Recursive:       true,
+		SortBy:          "natural",
+		Extensions:      ParseExtensions(DefaultExtensions),
+		Jobs:            runtime.NumCPU(),
+		Timeout:         30 * time.Second,
+		AutoRotate:      true,
+		WarnPixels:      50_000_000,
+		SVGDPI:          96,
+		CaptionPosition: "below",
+		BorderColor:     "000000",
+		BorderWidth:     "thin",
+		StartCell:       "B4",
+		Layout:          "horizontal",
+		Width:           defaultWidth,
+		Height:          defaultHeight,
+		RowStep:         36,
+		Cols:            2,
+		Rows:            2,
+		PageBreak:       "each",
+		TitleCell:       "A1",
+		GroupDelimiters: "_",
+		GroupGap:        2,
+		TimeFormat:      "2006-01-02 15:04:05",
+		BreakEvery:      2,
+		BreakRow:        40,
+	}
+}
+
+// targets returns the sheets to populate and their image folders
+func (opts Options) targets() []SheetTarget {
+	if len(opts.Sheets) > 0 {
+		return slices.Clone(opts.Sheets)
+	}
+	return []SheetTarget{{SheetName: opts.SheetName, FolderPath: opts.FolderPath}}
+}
+
+// insertFiles writes the images of each immediate subfolder into its own copy
+// of the template, saved as <opts.OutPath>/<subfolder>.xlsx. The images go on
+// opts.SheetName, or on a sheet named after the subfolder when it is empty.
+// Up to opts.Jobs workbooks are written concurrently, sharing the jobs between
+// them. Their log lines, prefixed with the workbook's file name, output and
+// placements are held back and passed on in subfolder order, so they read the
+// same whatever order the workbooks finish in.
+func insertFiles(opts Options) error {
+	targets, err := splitByFolder(opts.targets(), opts)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun {
+		if err := os.MkdirAll(opts.OutPath, 0o755); err != nil {
+			return fmt.Errorf("Failed to create output directory: %w", err)
+		}
+	}
+
+	workers := min(opts.Jobs, len(targets))
+	type result struct {
+		outPath    string
+		log        bytes.Buffer
+		output     bytes.Buffer
+		warnings   bytes.Buffer
+		placements []Placement
+		skips      []Skip
+		err        error
+		done       chan struct{}
+	}
+	results := make([]*result, len(targets))
+	for i := range results {
+		results[i] = &result{done: make(chan struct{})}
+	}
+
+	var stop atomic.Bool
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				r, target := results[i], targets[i]
+				fileOpts := opts
+				fileOpts.SplitByFolder, fileOpts.SplitFiles = false, false
+				fileOpts.Sheets = nil
+				fileOpts.FolderPath = target.FolderPath
+				fileOpts.OutPath = filepath.Join(opts.OutPath, sanitizeFileName(filepath.Base(target.FolderPath))+".xlsx")
+				if opts.SheetName == "" && opts.SheetIndex < 0 {
+					fileOpts.SheetName = target.SheetName
+					fileOpts.CreateSheet = true
+				}
+				r.outPath = fileOpts.OutPath
+				fileOpts.Jobs = max(opts.Jobs/workers, 1)
+				if opts.Logger != nil {
+					prefix := opts.Logger.Prefix() + filepath.Base(fileOpts.OutPath) + ": "
+					fileOpts.Logger = log.New(&r.log, prefix, opts.Logger.Flags())
+				}
+				fileOpts.Output = &r.output
+				fileOpts.Warnings = &r.warnings
+				fileOpts.OnPlace = func(p Placement) { r.placements = append(r.placements, p) }
+				fileOpts.OnSkip = func(s Skip) { r.skips = append(r.skips, s) }
+				if workers > 1 {
+					// Counts of several workbooks would overwrite each other
+					fileOpts.Progress = nil
+				}
+				if !stop.Load() {
+					fileOpts.logf("Writing %s to %s", target.FolderPath, fileOpts.OutPath)
+					r.err = Insert(fileOpts)
+				}
+				close(r.done)
+			}
+		}()
+	}
+	go func() {
+		for i := range targets {
+			indexes <- i
+		}
+		close(indexes)
+	}()
+
+	var errs []error
+	for _, r := range results {
+		<-r.done
+		if opts.Logger != nil {
+			opts.Logger.Writer().Write(r.log.Bytes())
+		}
+		opts.output().Write(r.output.Bytes())
+		opts.warnings().Write(r.warnings.Bytes())
+		for _, p := range r.placements {
+			opts.place(p)
+		}
+		for _, s := range r.skips {
+			opts.skip(s)
+		}
+		if r.err == nil {
+			continue
+		}
+		err := fmt.Errorf("%s: %w", r.outPath, r.err)
+		if !opts.ContinueOnError {
+			// Let the workbooks in progress finish, but start no more
+			stop.Store(true)
+			wg.Wait()
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// sanitizeFileName replaces the characters Windows does not allow in file
+// names, so the workbooks can be copied to any platform
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// splitByFolder returns a target for each immediate subfolder of the target
+// folders, on a sheet named after the subfolder
+func splitByFolder(targets []SheetTarget, opts Options) ([]SheetTarget, error) {
+	var split []SheetTarget
+	for _, target := range targets {
+		if isZipArchive(target.FolderPath) {
+			return nil, fmt.Errorf("The -split-by-folder flag does not support ZIP archives: %s", target.FolderPath)
+		}
+		entries, err := os.ReadDir(target.FolderPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read folder %s: %w", target.FolderPath, err)
+		}
+		var folders []string
+		for _, entry := range entries {
+			if entry.IsDir() {
+				folders = append(folders, entry.Name())
+			}
+		}
+		if len(folders) == 0 {
+			return nil, fmt.Errorf("No subfolders found in %s to split into sheets", target.FolderPath)
+		}
+		sort.Slice(folders, func(i, j int) bool {
+			return naturalLess(folders[i], folders[j])
+		})
+		for _, folder := range folders {
+			split = append(split, SheetTarget{
+				SheetName:  folder,
+				FolderPath: filepath.Join(target.FolderPath, folder),
+			})
+		}
+	}
+	return split, nil
+}
+
+// skip reports an image left out to the OnSkip callback, if any
+func (opts Options) skip(s Skip) {
+	if opts.OnSkip != nil {
+		opts.OnSkip(s)
+	}
+}
+
+// place reports the placement of an image to the OnPlace callback, if any
+func (opts Options) place(p Placement) {
+	if opts.OnPlace != nil {
+		opts.OnPlace(p)
+	}
+}
+
+// logf writes a progress message to the logger, if any
+func (opts Options) logf(format string, args ...any) {
+	if opts.Logger != nil {
+		opts.Logger.Printf(format, args...)
+	}
+}
+
+// input returns the reader of the image paths for the StdinFolder
+func (opts Options) input() io.Reader {
+	if opts.Input != nil {
+		return opts.Input
+	}
+	return os.Stdin
+}
+
+// output returns the writer receiving the dry run plan
+func (opts Options) output() io.Writer {
+	if opts.Output != nil {
+		return opts.Output
+	}
+	return os.Stdout
+}
+
+// warnings returns the writer receiving warnings
+func (opts Options) warnings() io.Writer {
+	if opts.Warnings != nil {
+		return opts.Warnings
+	}
+	return os.Stderr
+}
+
+// Insert inserts the images of each target folder into its sheet of the
+// template and saves the workbook to opts.OutPath, or over the template when
+// it is empty. In dry run mode the plan is printed and nothing is saved.
+func Insert(opts Options) error {
+	// A printed size replaces the size in pixels everywhere
+	if opts.WidthCM > 0 || opts.HeightCM > 0 {
+		opts.Width, opts.Height = opts.WidthCM*pixelsPerCM, opts.HeightCM*pixelsPerCM
+	}
+
+	// Validate inputs
+	if err := validateOptions(opts); err != nil {
+		return err
+	}
+
+	if opts.SplitByFolder && opts.SplitFiles {
+		return insertFiles(opts)
+	}
+
+	// Open the existing Excel template file
+	f, err := openExcelFile(opts.TemplatePath, opts.Password)
+	if err != nil {
+		return fmt.Errorf("Failed to open template file: %w", err)
+	}
+	defer f.Close()
+
+	if opts.SheetName, err = sheetAtIndex(f, opts); err != nil {
+		return err
+	}
+
+	// Make sure every target sheet exists before any image is processed
+	targets := opts.targets()
+	sheetOpts := opts
+	if opts.SplitByFolder {
+		if targets, err = splitByFolder(targets, opts); err != nil {
+			return err
+		}
+		sheetOpts.CreateSheet = true
+	}
+	if err := sanitizeTargets(targets, opts); err != nil {
+		return err
+	}
+	if err := validateSheets(f, targets, sheetOpts); err != nil {
+		return err
+	}
+
+	if opts.TOC {
+		opts.contents = &contents{}
+	}
+	if opts.ContinueOnError {
+		opts.failures = &failures{}
+	}
+	if opts.Dedupe {
+		opts.duplicates = newDuplicates()
+	}
+
+	// Insert each folder's images into its sheet, starting at the configured cell
+	total := 0
+	for _, target := range targets {
+		sheetOpts := opts
+		if sheetOpts.StartCell, err = resolveStartCell(f, target.SheetName, opts.TemplateSheet, opts.StartCell); err != nil {
+			return fmt.Errorf("Invalid start cell %q: %w", opts.StartCell, err)
+		}
+		if opts.CountCell != "" {
+			if sheetOpts.CountCell, err = resolveStartCell(f, target.SheetName, opts.TemplateSheet, opts.CountCell); err != nil {
+				return fmt.Errorf("Invalid count cell %q: %w", opts.CountCell, err)
+			}
+		}
+		imageFiles, err := getImageFiles(target.FolderPath, opts)
+		if err != nil {
+			return fmt.Errorf("Error collecting image files: %w", err)
+		}
+		if len(imageFiles) == 0 {
+			return errorf(ErrNoImages, "No images found in %s matching the accepted extensions and filters", target.FolderPath)
+		}
+		if !opts.Stream {
+			if imageFiles, err = loadImages(imageFiles, opts); err != nil {
+				return fmt.Errorf("Error reading images: %w", err)
+			}
+		}
+		if imageFiles, err = opts.duplicates.filter(imageFiles, opts); err != nil {
+			return fmt.Errorf("Error reading images: %w", err)
+		}
+		sheetNames, err := rolloverSheets(f, target.SheetName, len(imageFiles), opts)
+		if err != nil {
+			return err
+		}
+		for i, sheetName := range sheetNames {
+			sheetTarget := SheetTarget{SheetName: sheetName, FolderPath: target.FolderPath}
+			chunk := imageFiles
+			if opts.MaxPerSheet > 0 {
+				chunk = imageFiles[i*opts.MaxPerSheet : min((i+1)*opts.MaxPerSheet, len(imageFiles))]
+			}
+			if err := insertSheet(f, sheetTarget, chunk, sheetOpts); err != nil {
+				return err
+			}
+		}
+		total += len(imageFiles)
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(opts.output(), "Dry run: %d images planned, no changes written\n", total)
+		return opts.failures.err()
+	}
+
+	if opts.TOC {
+		if err := writeContents(f, opts.contents); err != nil {
+			return fmt.Errorf("Error writing the table of contents: %w", err)
+		}
+	}
+	if opts.Cover != "" {
+		if err := addCover(f, opts.Cover, total-opts.failures.count(), opts.Title); err != nil {
+			return fmt.Errorf("Error writing the cover sheet: %w", err)
+		}
+	}
+
+	if opts.writer != nil {
+		if _, err := f.WriteTo(opts.writer); err != nil {
+			return fmt.Errorf("Failed to write updated file: %w", err)
+		}
+		return opts.failures.err()
+	}
+
+	// Save the changes to the output file, or the template itself if none is given
+	if err := saveExcelFile(f, opts); err != nil {
+		return fmt.Errorf("Failed to save updated file: %w", err)
+	}
+	return opts.failures.err()
+}
+
+// InsertTo inserts the images like Insert but writes the workbook to w in
+// xlsx format instead of saving it, leaving the template unchanged, so it can
+// be sent to a client without touching the disk. opts.OutPath is ignored and
+// splitting into several files is not supported. In dry run mode nothing is
+// written.
+func InsertTo(w io.Writer, opts Options) error {
+	if opts.SplitFiles {
+		return fmt.Errorf("The -split-files flag cannot be used when writing the workbook to a stream.")
+	}
+	opts.writer = w
+	return Insert(opts)
+}
+
+// InsertBytes inserts the images like InsertTo and returns the xlsx bytes of
+// the workbook. With opts.ContinueOnError the workbook is returned along with
+// the error listing the skipped images.
+func InsertBytes(opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	err := InsertTo(&buf, opts)
+	if buf.Len() == 0 {
+		return nil, err
+	}
+	return buf.Bytes(), err
+}
+
+// insertSheet writes the title and metadata of the sheet and inserts the
+// images into it, followed by its image count, print area and footer
+func insertSheet(f *excelize.File, target SheetTarget, images []ImageInfo, opts Options) error {
+	var err error
+	if opts.Append {
+		if opts.StartCell, err = appendStart(f, target.SheetName, opts); err != nil {
+			return fmt.Errorf("Error finding the images already in sheet %s: %w", target.SheetName, err)
+		}
+	}
+	if opts.Title != "" && !opts.DryRun {
+		if err := addTitle(f, target.SheetName, opts.TitleCell, opts.Title); err != nil {
+			return fmt.Errorf("Error writing title into sheet %s: %w", target.SheetName, err)
+		}
+	}
+	metaCell := opts.StartCell
+	if opts.Meta {
+		if opts, err = reserveMetadata(opts); err != nil {
+			return fmt.Errorf("Error writing metadata into sheet %s: %w", target.SheetName, err)
+		}
+	}
+	opts.progress = newProgress(opts.Progress, target.SheetName, len(images))
+	if opts.PrintArea {
+		opts.area = &printArea{}
+	}
+	skipped := opts.failures.count()
+	if err := pasteImages(f, target.SheetName, images, opts); err != nil {
+		return fmt.Errorf("Error inserting images into sheet %s: %w", target.SheetName, err)
+	}
+	inserted := len(images) - (opts.failures.count() - skipped)
+	if opts.Meta && !opts.DryRun {
+		if err := addMetadata(f, target, metaCell, inserted); err != nil {
+			return fmt.Errorf("Error writing metadata into sheet %s: %w", target.SheetName, err)
+		}
+	}
+	if opts.CountCell != "" && !opts.DryRun {
+		if err := f.SetCellValue(target.SheetName, opts.CountCell, inserted); err != nil {
+			return fmt.Errorf("Error writing the image count into sheet %s: %w", target.SheetName, err)
+		}
+	}
+	if opts.PrintArea && !opts.DryRun {
+		if err := setPrintArea(f, target.SheetName, opts.area); err != nil {
+			return fmt.Errorf("Error setting the print area of sheet %s: %w", target.SheetName, err)
+		}
+	}
+	if !opts.DryRun {
+		if err := setFooter(f, target.SheetName, opts); err != nil {
+			return fmt.Errorf("Error setting the footer of sheet %s: %w", target.SheetName, err)
+		}
+	}
+	return nil
+}
+
+// sheetAtIndex returns the name of the sheet at opts.SheetIndex, or
+// opts.SheetName when no index is given
+func sheetAtIndex(f *excelize.File, opts Options) (string, error) {
+	if opts.SheetIndex < 0 {
+		return opts.SheetName, nil
+	}
+	sheets := f.GetSheetList()
+	if opts.SheetIndex >= len(sheets) {
+		return "", errorf(ErrSheetNotFound, "The sheet index %d is out of range, the workbook has %d sheets: %s", opts.SheetIndex, len(sheets), strings.Join(sheets, ", "))
+	}
+	opts.logf("Sheet index %d is sheet %s", opts.SheetIndex, sheets[opts.SheetIndex])
+	return sheets[opts.SheetIndex], nil
+}
+
+// rolloverSheets returns the sheets that count images go on, splitting them
+// over sheets of opts.MaxPerSheet images. The first is sheetName and the
+// others, named sheetName_2, sheetName_3 and so on, are copied from it before
+// any image is inserted, or reused when the workbook already has them.
+func rolloverSheets(f *excelize.File, sheetName string, count int, opts Options) ([]string, error) {
+	names := []string{sheetName}
+	if opts.MaxPerSheet <= 0 {
+		return names, nil
+	}
+	index, err := f.GetSheetIndex(sheetName)
+	if err != nil {
+		return nil, err
+	}
+	for n := 2; (n-1)*opts.MaxPerSheet < count; n++ {
+		suffix := fmt.Sprintf("_%d", n)
+		name := sheetName
+		if len([]rune(name))+len(suffix) > maxSheetNameLength {
+			name = string([]rune(name)[:maxSheetNameLength-len(suffix)])
+		}
+		name += suffix
+		if existing, _ := f.GetSheetIndex(name); existing < 0 {
+			newIndex, err := f.NewSheet(name)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to create sheet %q: %w", name, err)
+			}
+			if err := f.CopySheet(index, newIndex); err != nil {
+				return nil, fmt.Errorf("Failed to copy sheet %q to %q: %w", sheetName, name, err)
+			}
+			opts.logf("Created sheet %s for images past %d", name, (n-1)*opts.MaxPerSheet)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// validateOptions checks if the provided folders, sheets, and excel file path
+// and the scan, load and layout options are valid.
+func validateOptions(opts Options) error {
+	targets := opts.targets()
+	for _, target := range targets {
+		if target.FolderPath == "" {
+			return fmt.Errorf("Please provide the image folder path using the -folder flag.")
+		}
+		if target.SheetName == "" && opts.SheetIndex < 0 && !opts.SplitByFolder {
+			return fmt.Errorf("Please provide the sheet name using the -sheet or -sheet-index flag.")
+		}
+	}
+	if opts.SheetIndex >= 0 && (opts.SheetName != "" || len(opts.Sheets) > 0) {
+		return fmt.Errorf("The -sheet-index flag cannot be combined with -sheet or -map.")
+	}
+	if opts.SplitFiles && !opts.SplitByFolder {
+		return fmt.Errorf("The -split-files flag requires -split-by-folder.")
+	}
+	if opts.SplitFiles && opts.OutPath == "" {
+		return fmt.Errorf("Please provide the output directory using the -out flag.")
+	}
+	if opts.TemplatePath == "" {
+		return fmt.Errorf("Please provide the excel file path using the -excel flag.")
+	}
+	if opts.Jobs <= 0 {
+		return fmt.Errorf("The number of jobs must be positive: %d", opts.Jobs)
+	}
+	if !opts.Since.IsZero() && !opts.Until.IsZero() && opts.Until.Before(opts.Since) {
+		return fmt.Errorf("The -until time %s is before the -since time %s.", opts.Until.Format(time.RFC3339), opts.Since.Format(time.RFC3339))
+	}
+	if opts.MaxFileSize < 0 {
+		return fmt.Errorf("The maximum file size must not be negative: %g", opts.MaxFileSize)
+	}
+	if opts.Timeout < 0 {
+		return fmt.Errorf("The fetch timeout must not be negative: %s", opts.Timeout)
+	}
+	if opts.Quality < 0 || opts.Quality > 100 {
+		return fmt.Errorf("The JPEG quality must be between 1 and 100: %d", opts.Quality)
+	}
+	if opts.FlattenBG != "" && !hexColor.MatchString(opts.FlattenBG) {
+		return fmt.Errorf("Invalid background color %q. Use a hex RGB color such as FFFFFF.", opts.FlattenBG)
+	}
+	if _, ok := rotations[opts.Rotate]; !ok && opts.Rotate != 0 {
+		return fmt.Errorf("Invalid rotation %d, expected 0, 90, 180 or 270.", opts.Rotate)
+	}
+	if opts.SVGDPI <= 0 {
+		return fmt.Errorf("The SVG resolution must be positive: %d", opts.SVGDPI)
+	}
+	if opts.GIFFrame < 0 {
+		return fmt.Errorf("The GIF frame index must not be negative: %d", opts.GIFFrame)
+	}
+	if opts.Append && (opts.PlacementFile != "" || opts.Table != "") {
+		return fmt.Errorf("The -append flag cannot be combined with -placement or -table.")
+	}
+	if opts.Table != "" && opts.PlacementFile != "" {
+		return fmt.Errorf("A table cannot be combined with a placement file.")
+	}
+	if opts.PlacementFile != "" && opts.Manifest != "" {
+		return fmt.Errorf("A placement file cannot be combined with a manifest.")
+	}
+	if opts.Border {
+		if _, ok := borderStyles[opts.BorderWidth]; !ok {
+			return fmt.Errorf("Invalid border width %q. Use thin, medium or thick.", opts.BorderWidth)
+		}
+		if !hexColor.MatchString(opts.BorderColor) {
+			return fmt.Errorf("Invalid border color %q. Use a hex RGB color such as 000000.", opts.BorderColor)
+		}
+	}
+	if opts.ColumnsFromTemplate && (opts.Layout != "horizontal" || opts.FitCells || opts.AutoFit) {
+		return fmt.Errorf("The -columns-from-template flag requires the horizontal layout and cannot be combined with -fit-cells or -autofit.")
+	}
+	if opts.AutoFit && opts.FitCells {
+		return fmt.Errorf("The -autofit flag cannot be combined with -fit-cells.")
+	}
+	if opts.MaxPerSheet < 0 {
+		return fmt.Errorf("The maximum number of images per sheet must not be negative: %d", opts.MaxPerSheet)
+	}
+	if opts.Retry < 0 {
+		return fmt.Errorf("The retry count must not be negative: %d", opts.Retry)
+	}
+	if opts.WarnPixels < 0 {
+		return fmt.Errorf("The pixel warning threshold must not be negative: %d", opts.WarnPixels)
+	}
+	if opts.MaxDim < 0 {
+		return fmt.Errorf("The maximum image dimension must not be negative: %d", opts.MaxDim)
+	}
+	if len(opts.Extensions) == 0 {
+		return fmt.Errorf("Please provide at least one image extension using the -ext flag.")
+	}
+	if _, err := matchGlob(opts.Glob, "x"); err != nil {
+		return fmt.Errorf("Invalid glob pattern %q: %w", opts.Glob, err)
+	}
+	if opts.Skip < 0 {
+		return fmt.Errorf("The number of images to skip must not be negative: %d", opts.Skip)
+	}
+	if opts.Limit < 0 {
+		return fmt.Errorf("The image limit must not be negative: %d", opts.Limit)
+	}
+	switch opts.SortBy {
+	case "name", "natural", "mtime", "size":
+	default:
+		return fmt.Errorf("Invalid sort order %q, expected name, natural, mtime or size.", opts.SortBy)
+	}
+	if opts.Title != "" {
+		if _, _, err := excelize.CellNameToCoordinates(opts.TitleCell); err != nil {
+			return fmt.Errorf("Invalid title cell %q: %w", opts.TitleCell, err)
+		}
+	}
+	if opts.StartCell == "" {
+		return fmt.Errorf("Please provide the start cell or defined name using the -start flag.")
+	}
+	if opts.Layout != "horizontal" && opts.Layout != "vertical" && opts.Layout != "grid" {
+		return fmt.Errorf("Invalid layout %q, expected horizontal, vertical or grid.", opts.Layout)
+	}
+	switch opts.Positioning {
+	case "", "oneCell", "twoCell", "absolute":
+	default:
+		return fmt.Errorf("Invalid positioning %q, expected oneCell, twoCell or absolute.", opts.Positioning)
+	}
+	if opts.OffsetX < 0 || opts.OffsetY < 0 {
+		return fmt.Errorf("The image offsets must not be negative: %d, %d", opts.OffsetX, opts.OffsetY)
+	}
+	if (opts.WidthCM > 0 || opts.HeightCM > 0) && (opts.WidthCM <= 0 || opts.HeightCM <= 0) {
+		return fmt.Errorf("The printed image width and height must both be positive: %gx%g cm", opts.WidthCM, opts.HeightCM)
+	}
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return fmt.Errorf("The image width and height must be positive: %gx%g", opts.Width, opts.Height)
+	}
+	if opts.ColStep < 0 {
+		return fmt.Errorf("The column step must not be negative: %d", opts.ColStep)
+	}
+	if opts.Spacing < 0 {
+		return fmt.Errorf("The spacing must not be negative: %g", opts.Spacing)
+	}
+	if opts.Spacing > 0 && (opts.ColStep > 0 || opts.ColumnsFromTemplate) {
+		return fmt.Errorf("The -spacing flag cannot be combined with -col-step or -columns-from-template.")
+	}
+	if opts.RowStep <= 0 {
+		return fmt.Errorf("The row step must be positive: %d", opts.RowStep)
+	}
+	switch opts.PageBreak {
+	case "each", "none":
+	case "every-n":
+		if opts.BreakEvery <= 0 {
+			return fmt.Errorf("The page break interval must be positive: %d", opts.BreakEvery)
+		}
+	default:
+		return fmt.Errorf("Invalid page break mode %q, expected each, none or every-n.", opts.PageBreak)
+	}
+	if opts.BreakRow <= 0 {
+		return fmt.Errorf("The page break row must be positive: %d", opts.BreakRow)
+	}
+	if opts.TimestampCaption && opts.TimeFormat == "" {
+		return fmt.Errorf("Please provide the timestamp layout using the -time-format flag.")
+	}
+	if opts.GroupGap < 0 {
+		return fmt.Errorf("The group gap must not be negative: %d", opts.GroupGap)
+	}
+	if opts.CaptionPosition != "below" && opts.CaptionPosition != "above" {
+		return fmt.Errorf("Invalid caption position %q, expected above or below.", opts.CaptionPosition)
+	}
+	if opts.CaptionOffset < 0 {
+		return fmt.Errorf("The caption offset must not be negative: %d", opts.CaptionOffset)
+	}
+	if opts.Layout == "grid" && (opts.Cols <= 0 || opts.Rows <= 0) {
+		return fmt.Errorf("The grid columns and rows must be positive: %dx%d", opts.Cols, opts.Rows)
+	}
+	if opts.Cover != "" {
+		if _, err := os.Stat(opts.Cover); os.IsNotExist(err) {
+			return fmt.Errorf("The cover file does not exist: %s", opts.Cover)
+		}
+	}
+	for _, target := range targets {
+		if target.FolderPath == StdinFolder {
+			continue
+		}
+		if _, err := os.Stat(target.FolderPath); os.IsNotExist(err) {
+			return fmt.Errorf("The folder path does not exist: %s", target.FolderPath)
+		}
+	}
+	return nil
+}
+
+// validateSheets checks that every target sheet exists in the workbook. With
+// opts.CreateSheet set, absent sheets are created instead and the first one
+// created becomes the active sheet. With opts.TemplateSheet set, every target
+// must be a new sheet and is created as a copy of the template sheet.
+func validateSheets(f *excelize.File, targets []SheetTarget, opts Options) error {
+	sheets := f.GetSheetList()
+	templateIndex := -1
+	if opts.TemplateSheet != "" {
+		var err error
+		if templateIndex, err = f.GetSheetIndex(opts.TemplateSheet); err != nil || templateIndex < 0 {
+			return errorf(ErrSheetNotFound, "The template sheet %q does not exist in the workbook. Available sheets: %s", opts.TemplateSheet, strings.Join(sheets, ", "))
+		}
+	}
+	activeSet := false
+	for _, target := range targets {
+		if slices.Contains(sheets, target.SheetName) {
+			if templateIndex >= 0 {
+				return fmt.Errorf("The sheet %q already exists, choose a new sheet name to copy %q into.", target.SheetName, opts.TemplateSheet)
+			}
+			continue
+		}
+		if !opts.CreateSheet && templateIndex < 0 {
+			return errorf(ErrSheetNotFound, "The sheet %q does not exist in the workbook. Available sheets: %s", target.SheetName, strings.Join(sheets, ", "))
+		}
+		index, err := f.NewSheet(target.SheetName)
+		if err != nil {
+			return fmt.Errorf("Failed to create sheet %q: %w", target.SheetName, err)
+		}
+		if templateIndex >= 0 {
+			if err := f.CopySheet(templateIndex, index); err != nil {
+				return fmt.Errorf("Failed to copy sheet %q to %q: %w", opts.TemplateSheet, target.SheetName, err)
+			}
+			opts.logf("Copied sheet %s to %s", opts.TemplateSheet, target.SheetName)
+		} else {
+			opts.logf("Created sheet %s", target.SheetName)
+		}
+		if !activeSet {
+			f.SetActiveSheet(index)
+			activeSet = true
+		}
+		sheets = append(sheets, target.SheetName)
+	}
+	return nil
+}
+
+// sanitizeSheetName replaces the characters Excel does not allow in sheet
+// names and truncates the name to Excel's 31 character limit
+func sanitizeSheetName(original string, opts Options) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return '_'
+		}
+		return r
+	}, original)
+	name = strings.Trim(name, "'")
+	if runes := []rune(name); len(runes) > maxSheetNameLength {
+		name = string(runes[:maxSheetNameLength])
+	}
+	if name == "" {
+		name = "Sheet"
+	}
+	if name != original {
+		opts.logf("Sheet name %q sanitized to %q", original, name)
+	}
+	return name
+}
+
+// sanitizeTargets sanitizes the sheet names of the targets. Two different
+// names that become the same sheet, which Excel compares case-insensitively,
+// are an error, as the images of the second would be pasted over the first.
+func sanitizeTargets(targets []SheetTarget, opts Options) error {
+	originals := map[string]string{}
+	for i, target := range targets {
+		targets[i].SheetName = sanitizeSheetName(target.SheetName, opts)
+		key := strings.ToLower(targets[i].SheetName)
+		if other, ok := originals[key]; ok && other != target.SheetName {
+			return fmt.Errorf("The sheet names %q and %q both become sheet %q. Rename one of the folders.", other, target.SheetName, targets[i].SheetName)
+		}
+		originals[key] = target.SheetName
+	}
+	return nil
+}
+
+// openExcelFile opens the specified Excel template file, decrypting it with
+// the password when one is given. Saving the file encrypts it again with the
+// same password.
+func openExcelFile(templatePath, password string) (*excelize.File, error) {
+	f, err := excelize.OpenFile(templatePath, excelize.Options{Password: password})
+	if err == nil {
+		return f, nil
+	}
+	switch {
+	case !isEncrypted(templatePath):
+		return nil, err
+	case password == "":
+		return nil, errorf(ErrPasswordProtected, "the workbook is password protected, use -password")
+	default:
+		return nil, errorf(ErrPasswordProtected, "the password of the workbook is not correct")
+	}
+}
+
+// isEncrypted reports whether the file is an encrypted Office document: an
+// OLE compound file with an EncryptionInfo stream, rather than a plain xlsx
+// ZIP archive or a legacy xls file
+func isEncrypted(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	ole := []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+	var encryptionInfo []byte
+	for _, c := range "EncryptionInfo" {
+		encryptionInfo = append(encryptionInfo, byte(c), 0) // UTF-16 stream name
+	}
+	return bytes.HasPrefix(data, ole) && bytes.Contains(data, encryptionInfo)
+}
+
+// saveExcelFile saves the Excel file to opts.OutPath, or back to the file it
+// was opened from when no output path is given. While another program such
+// as Excel holds the file open, saving is retried opts.Retry times, waiting
+// twice as long before each attempt.
+func saveExcelFile(f *excelize.File, opts Options) error {
+	path := opts.OutPath
+	if path == "" {
+		path = f.Path
+	}
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err := f.SaveAs(path)
+		if err == nil || !isFileLocked(err) {
+			return err
+		}
+		if attempt >= opts.Retry {
+			return errorf(ErrFileLocked, "%s is open in another program, close the file in Excel and retry", path)
+		}
+		fmt.Fprintf(opts.warnings(), "%s is open in another program, retrying in %v...\n", path, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isFileLocked reports whether err is Windows refusing access to a file
+// another process has open, which is how Excel guards open workbooks
+func isFileLocked(err error) bool {
+	const errorSharingViolation, errorLockViolation = 32, 33
+	var errno syscall.Errno
+	if runtime.GOOS != "windows" || !errors.As(err, &errno) {
+		return false
+	}
+	return errno == errorSharingViolation || errno == errorLockViolation
+}
+
+// ConvertToPDF renders the workbook at xlsxPath to pdfPath using a headless
+// LibreOffice, which must be installed and on the PATH
+func ConvertToPDF(xlsxPath, pdfPath string) error {
+	converter, err := exec.LookPath("soffice")
+	if err != nil {
+		if converter, err = exec.LookPath("libreoffice"); err != nil {
+			return fmt.Errorf("LibreOffice (soffice) was not found on the PATH, install it to use -pdf")
+		}
+	}
+
+	// LibreOffice names the output after the input file, so convert into a
+	// temporary folder and move the result into place
+	tmpDir, err := os.MkdirTemp("", "evidence-pdf")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cmd := exec.Command(converter, "--headless", "--convert-to", "pdf", "--outdir", tmpDir, xlsxPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", filepath.Base(converter), err, strings.TrimSpace(string(output)))
+	}
+
+	name := strings.TrimSuffix(filepath.Base(xlsxPath), filepath.Ext(xlsxPath)) + ".pdf"
+	data, err := os.ReadFile(filepath.Join(tmpDir, name))
+	if err != nil {
+		return fmt.Errorf("converted PDF not found: %w", err)
+	}
+	return os.WriteFile(pdfPath, data, 0o644)
+}
@@ -0,0 +1,1748 @@
+package evidence
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/image/tiff"
+)
+
+const (
+	testImages   = "testdata/images"
+	testTemplate = "testdata/template.xlsx"
+	testSheet    = "Evidence"
+)
+
+// testOptions returns the default options for the test template, with the
+// sample template's 37 column step
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.FolderPath = testImages
+	opts.SheetName = testSheet
+	opts.TemplatePath = testTemplate
+	opts.ColStep = 37
+	return opts
+}
+
+// relPaths returns the relative paths of the images
+func relPaths(images []ImageInfo) []string {
+	var paths []string
+	for _, img := range images {
+		paths = append(paths, filepath.ToSlash(img.RelPath))
+	}
+	return paths
+}
+
+func TestGetImageFilesOrder(t *testing.T) {
+	images, err := getImageFiles(testImages, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cover.png", "img1.png", "img2.jpg", "img10.png", "img20.png"}
+	if got := relPaths(images); !slices.Equal(got, want) {
+		t.Errorf("getImageFiles order = %v, want %v", got, want)
+	}
+}
+
+func TestGetImageFilesSkipLimit(t *testing.T) {
+	tests := []struct {
+		skip, limit int
+		want        []string
+	}{
+		{0, 2, []string{"cover.png", "img1.png"}},
+		{2, 0, []string{"img2.jpg", "img10.png", "img20.png"}},
+		{1, 2, []string{"img1.png", "img2.jpg"}},
+		{9, 0, nil},
+	}
+	for _, tt := range tests {
+		opts := testOptions()
+		opts.Skip, opts.Limit = tt.skip, tt.limit
+		images, err := getImageFiles(testImages, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := relPaths(images); !slices.Equal(got, tt.want) {
+			t.Errorf("skip %d limit %d: images = %v, want %v", tt.skip, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestGetImageFilesTrailingSeparator(t *testing.T) {
+	want, err := getImageFiles(testImages, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 5 {
+		t.Fatalf("got %d images, want 5", len(want))
+	}
+	for _, folder := range []string{testImages + string(filepath.Separator), testImages + "/", testImages + "//"} {
+		images, err := getImageFiles(folder, testOptions())
+		if err != nil {
+			t.Fatalf("%q: %v", folder, err)
+		}
+		if len(images) != len(want) {
+			t.Fatalf("%q: got %d images, want %d", folder, len(images), len(want))
+		}
+		for i, img := range images {
+			// The same clean paths as without the trailing separator
+			if img.FilePath != want[i].FilePath {
+				t.Errorf("%q: image path = %q, want %q", folder, img.FilePath, want[i].FilePath)
+			}
+			if _, err := os.Stat(img.FilePath); err != nil {
+				t.Errorf("%q: image path not readable: %v", folder, err)
+			}
+		}
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"img20", "img1", "img10", "img2"}
+	sort.Slice(names, func(i, j int) bool {
+		return naturalLess(names[i], names[j])
+	})
+	want := []string{"img1", "img2", "img10", "img20"}
+	if !slices.Equal(names, want) {
+		t.Errorf("natural order = %v, want %v", names, want)
+	}
+
+	// The comparator is a strict order: each name sorts before every later
+	// one and never after it, nor before itself
+	for i, a := range want {
+		for j, b := range want {
+			if got := naturalLess(a, b); got != (i < j) {
+				t.Errorf("naturalLess(%q, %q) = %v, want %v", a, b, got, i < j)
+			}
+		}
+	}
+}
+
+// TestSortImagesContract pins down the exact order of each sort mode, so a
+// change to it is a deliberate one
+func TestSortImagesContract(t *testing.T) {
+	tests := []struct {
+		name    string
+		sortBy  string
+		reverse bool
+		files   []string
+		want    []string
+	}{
+		{
+			name:   "names without numbers first",
+			sortBy: "natural",
+			files:  []string{"b1.png", "summary.png", "a.png"},
+			want:   []string{"a.png", "summary.png", "b1.png"},
+		},
+		{
+			name:   "lexical numbers",
+			sortBy: "name",
+			files:  []string{"img10.png", "img2.png", "img1.png"},
+			want:   []string{"img1.png", "img10.png", "img2.png"},
+		},
+		{
+			name:   "natural numbers",
+			sortBy: "natural",
+			files:  []string{"img10.png", "img2.png", "img1.png"},
+			want:   []string{"img1.png", "img2.png", "img10.png"},
+		},
+		{
+			name:   "natural multiple number groups",
+			sortBy: "natural",
+			files:  []string{"run2_step10.png", "run2_step9.png", "run10_step1.png", "run1_step20.png"},
+			want:   []string{"run1_step20.png", "run2_step9.png", "run2_step10.png", "run10_step1.png"},
+		},
+		{
+			name:   "lexical multiple number groups",
+			sortBy: "name",
+			files:  []string{"run2_step10.png", "run2_step9.png", "run10_step1.png", "run1_step20.png"},
+			want:   []string{"run10_step1.png", "run1_step20.png", "run2_step10.png", "run2_step9.png"},
+		},
+		{
+			name:   "natural leading zeros, fewer zeros first",
+			sortBy: "natural",
+			files:  []string{"img010.png", "img9.png", "img10.png", "img0010.png", "img001.png"},
+			want:   []string{"img001.png", "img9.png", "img10.png", "img010.png", "img0010.png"},
+		},
+		{
+			name:   "lexical leading zeros",
+			sortBy: "name",
+			files:  []string{"img010.png", "img9.png", "img10.png", "img0010.png", "img001.png"},
+			want:   []string{"img001.png", "img0010.png", "img010.png", "img10.png", "img9.png"},
+		},
+		{
+			name:   "natural mixed alphanumerics, case sensitive",
+			sortBy: "natural",
+			files:  []string{"Shot 2b.png", "shot 3.png", "Shot 10.png", "Shot 2a.png"},
+			want:   []string{"Shot 2a.png", "Shot 2b.png", "Shot 10.png", "shot 3.png"},
+		},
+		{
+			name:   "subfolder paths",
+			sortBy: "natural",
+			files:  []string{"b/img1.png", "a/img2.png", "notes/cover.png"},
+			want:   []string{"notes/cover.png", "a/img2.png", "b/img1.png"},
+		},
+		{
+			name:    "reverse",
+			sortBy:  "natural",
+			reverse: true,
+			files:   []string{"img2.png", "cover.png", "img10.png"},
+			want:    []string{"img10.png", "img2.png", "cover.png"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images := make([]ImageInfo, len(tt.files))
+			for i, file := range tt.files {
+				images[i] = ImageInfo{RelPath: filepath.FromSlash(file)}
+			}
+			sortImages(images, tt.sortBy, tt.reverse)
+			if got := relPaths(images); !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetDimensions(t *testing.T) {
+	tests := []struct {
+		file          string
+		width, height int
+		format        string
+	}{
+		{"img1.png", 16, 9, "png"},
+		{"img2.jpg", 12, 8, "jpeg"},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join(testImages, tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		width, height, format, err := getDimensions(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.file, err)
+		}
+		if width != tt.width || height != tt.height || format != tt.format {
+			t.Errorf("%s: got %dx%d %s, want %dx%d %s", tt.file, width, height, format, tt.width, tt.height, tt.format)
+		}
+	}
+}
+
+func TestInsert(t *testing.T) {
+	opts := testOptions()
+	opts.Jobs = 2
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := excelize.OpenFile(opts.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	cells, err := out.GetPictureCells(testSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pictures := 0
+	for _, cell := range cells {
+		pics, err := out.GetPictures(testSheet, cell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pictures += len(pics)
+	}
+	if pictures != 5 {
+		t.Errorf("got %d pictures, want 5", pictures)
+	}
+
+	// Each page starts one column before its image, like the first page
+	// does before B4, and there is no break after the last image
+	breaks := columnBreaks(t, opts.OutPath)
+	if want := []int{37, 74, 111, 148}; !slices.Equal(breaks, want) {
+		t.Errorf("column breaks = %v, want %v", breaks, want)
+	}
+}
+
+// columnBreaks returns the ids of the manual column breaks in the first
+// worksheet of the workbook
+func columnBreaks(t *testing.T, path string) []int {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	file, err := r.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet struct {
+		ColBreaks struct {
+			Brk []struct {
+				ID int `xml:"id,attr"`
+			} `xml:"brk"`
+		} `xml:"colBreaks"`
+	}
+	if err := xml.Unmarshal(data, &sheet); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, brk := range sheet.ColBreaks.Brk {
+		ids = append(ids, brk.ID)
+	}
+	return ids
+}
+
+func TestImageLink(t *testing.T) {
+	img := ImageInfo{FilePath: filepath.Join(testImages, "sub", "shot 1.png"), RelPath: filepath.Join("sub", "shot 1.png")}
+	got, err := imageLink(img, "https://ci.example.com/shots/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://ci.example.com/shots/sub/shot%201.png"; got != want {
+		t.Errorf("imageLink = %q, want %q", got, want)
+	}
+	got, err = imageLink(img, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filepath.IsAbs(got) {
+		t.Errorf("imageLink without base = %q, want an absolute path", got)
+	}
+
+	// Fetched images link to their URL and archive entries to the archive
+	remote := ImageInfo{FilePath: "https://ci.example.com/run/1.png?raw=1", RelPath: "https://ci.example.com/run/1.png?raw=1"}
+	for _, baseURL := range []string{"", "https://ci.example.com/shots/"} {
+		if got, err := imageLink(remote, baseURL); err != nil || got != remote.FilePath {
+			t.Errorf("imageLink(%q) of a URL = %q, %v, want the URL", baseURL, got, err)
+		}
+	}
+	if got := captionText(remote); got != "1" {
+		t.Errorf("captionText of a URL = %q, want 1", got)
+	}
+	archive := filepath.Join(t.TempDir(), "shots.zip")
+	entry := ImageInfo{FilePath: filepath.Join(archive, "sub", "a.png"), RelPath: filepath.Join("sub", "a.png"), Source: []byte{}}
+	if got, err := imageLink(entry, ""); err != nil || got != archive {
+		t.Errorf("imageLink of an archive entry = %q, %v, want %q", got, err, archive)
+	}
+}
+
+func TestGetImageFilesZip(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "shots.zip")
+	file, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(file)
+	for _, name := range []string{"img10.png", "sub/img2.jpg", "img1.png", "notes.txt", "__MACOSX/._img1.png"} {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(testImages, path.Base(name)))
+		if err != nil {
+			data = []byte("not an image")
+		}
+		if _, err := entry.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions()
+	images, err := getImageFiles(zipPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"img1.png", "img10.png", "sub/img2.jpg"}; !slices.Equal(relPaths(images), want) {
+		t.Errorf("zip images = %v, want %v", relPaths(images), want)
+	}
+	if images, err = loadImages(images, opts); err != nil {
+		t.Fatal(err)
+	}
+	if images[0].Width != 16 || images[0].Height != 9 {
+		t.Errorf("img1.png from zip: got %dx%d, want 16x9", images[0].Width, images[0].Height)
+	}
+
+	opts.Recursive = false
+	if images, err = getImageFiles(zipPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"img1.png", "img10.png"}; !slices.Equal(relPaths(images), want) {
+		t.Errorf("non-recursive zip images = %v, want %v", relPaths(images), want)
+	}
+}
+
+func TestCheckDimensions(t *testing.T) {
+	for _, tt := range []struct {
+		width, height int
+		ok            bool
+	}{{16, 9, true}, {0, 9, false}, {16, 0, false}, {-1, 9, false}} {
+		if err := checkDimensions(tt.width, tt.height); (err == nil) != tt.ok {
+			t.Errorf("checkDimensions(%d, %d) = %v, want ok %v", tt.width, tt.height, err, tt.ok)
+		}
+	}
+}
+
+func TestRasterizeSVG(t *testing.T) {
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 20"><rect width="40" height="20" fill="red"/></svg>`)
+	opts := testOptions()
+	opts.Width, opts.Height, opts.SVGDPI = 100, 100, 192
+	data, err := rasterizeSVG(svg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fitting 40x20 within 100x100 gives 100x50, doubled by the resolution
+	if width, height, format, err := getDimensions(data); err != nil || width != 200 || height != 100 || format != "png" {
+		t.Errorf("got %dx%d %s (%v), want 200x100 png", width, height, format, err)
+	}
+}
+
+func TestPrepareImageTIFF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 7, 5)), nil); err != nil {
+		t.Fatal(err)
+	}
+	width, height, format, err := getDimensions(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 7 || height != 5 || format != "tiff" {
+		t.Fatalf("got %dx%d %s, want 7x5 tiff", width, height, format)
+	}
+	data, extension, err := prepareImage(buf.Bytes(), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, format, err := getDimensions(data); extension != ".png" || err != nil || format != "png" {
+		t.Errorf("prepared TIFF: extension %s, format %s, err %v, want PNG", extension, format, err)
+	}
+}
+
+func TestLoadImageMaxDim(t *testing.T) {
+	img := ImageInfo{FilePath: filepath.Join(testImages, "img1.png")}
+	opts := testOptions()
+	opts.MaxDim = 8
+	if err := loadImage(&img, opts); err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 8 || img.Height != 5 {
+		t.Errorf("downscaled img1.png: got %dx%d, want 8x5", img.Width, img.Height)
+	}
+}
+
+func TestGetImageFilesSeparators(t *testing.T) {
+	root := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(testImages, "img1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "sub/b.png", "sub/deeper/c.png"} {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"a.png", "sub/b.png", "sub/deeper/c.png"}
+	folders := []string{root, root + string(filepath.Separator), filepath.ToSlash(root) + "/"}
+	for _, folder := range folders {
+		images, err := getImageFiles(folder, testOptions())
+		if err != nil {
+			t.Fatalf("%q: %v", folder, err)
+		}
+		if got := relPaths(images); !slices.Equal(got, want) {
+			t.Errorf("%q: images = %v, want %v", folder, got, want)
+		}
+		for _, img := range images {
+			if want := filepath.Join(root, img.RelPath); img.FilePath != want {
+				t.Errorf("%q: file path = %q, want %q", folder, img.FilePath, want)
+			}
+		}
+	}
+}
+
+func TestResolveStartCell(t *testing.T) {
+	f, err := openExcelFile(testTemplate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	names := []*excelize.DefinedName{
+		{Name: "EvidenceStart", RefersTo: testSheet + "!$D$6:$F$9"},
+		{Name: "Origin", RefersTo: "Evidence!$C$3", Scope: testSheet},
+		{Name: "Origin", RefersTo: "Evidence!$H$8"},
+	}
+	for _, name := range names {
+		if err := f.SetDefinedName(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		start, want string
+	}{
+		{"B4", "B4"},
+		{"EvidenceStart", "D6"},
+		{"Origin", "C3"},
+	}
+	for _, tt := range tests {
+		got, err := resolveStartCell(f, testSheet, "", tt.start)
+		if err != nil {
+			t.Errorf("%s: %v", tt.start, err)
+		} else if got != tt.want {
+			t.Errorf("%s resolved to %s, want %s", tt.start, got, tt.want)
+		}
+	}
+	if _, err := resolveStartCell(f, testSheet, "", "Missing"); err == nil {
+		t.Error("expected an error for an unknown name")
+	}
+}
+
+func TestFitCell(t *testing.T) {
+	f, err := openExcelFile(testTemplate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := fitCell(f, testSheet, "C5", 159, 1000, testOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if width, err := f.GetColWidth(testSheet, "C"); err != nil || width != 22 {
+		t.Errorf("column width = %v (%v), want 22", width, err)
+	}
+	if height, err := f.GetRowHeight(testSheet, 5); err != nil || height != excelize.MaxRowHeight {
+		t.Errorf("row height = %v (%v), want %d", height, err, excelize.MaxRowHeight)
+	}
+}
+
+func TestInsertEmptyFolder(t *testing.T) {
+	folder := t.TempDir()
+	if err := os.WriteFile(filepath.Join(folder, "notes.txt"), []byte("no images here"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.FolderPath = folder
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	err := Insert(opts)
+	if !errors.Is(err, ErrNoImages) || !strings.Contains(err.Error(), "No images found in "+folder) {
+		t.Fatalf("Insert error = %v, want no images found", err)
+	}
+	if _, err := os.Stat(opts.OutPath); !os.IsNotExist(err) {
+		t.Errorf("output file written for an empty folder")
+	}
+}
+
+func TestGetImageFilesDuplicateNames(t *testing.T) {
+	root := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(testImages, "img1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"login", "checkout"} {
+		if err := os.Mkdir(filepath.Join(root, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, sub, "1.png"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	images, err := getImageFiles(root, testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"checkout/1.png", "login/1.png"}; !slices.Equal(relPaths(images), want) {
+		t.Fatalf("images = %v, want %v", relPaths(images), want)
+	}
+	var captions []string
+	for _, img := range images {
+		captions = append(captions, captionText(img))
+	}
+	if want := []string{"checkout/1", "login/1"}; !slices.Equal(captions, want) {
+		t.Errorf("captions = %v, want %v", captions, want)
+	}
+}
+
+func TestInsertSplitByFolder(t *testing.T) {
+	root := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(testImages, "img1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []string{"case10", "case2"} {
+		if err := os.Mkdir(filepath.Join(root, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, sub, "1.png"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := testOptions()
+	opts.FolderPath = root
+	opts.SheetName = ""
+	opts.SplitByFolder = true
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := excelize.OpenFile(opts.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if got, want := out.GetSheetList(), []string{testSheet, "case2", "case10"}; !slices.Equal(got, want) {
+		t.Errorf("sheets = %v, want %v", got, want)
+	}
+	for _, sheet := range []string{"case2", "case10"} {
+		if cells, err := out.GetPictureCells(sheet); err != nil || len(cells) != 1 {
+			t.Errorf("%s: picture cells = %v (%v), want one", sheet, cells, err)
+		}
+	}
+}
+
+func TestSanitizeSheetName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Login", "Login"},
+		{"Cart: pay?", "Cart_ pay_"},
+		{`a/b\c*[d]`, "a_b_c__d_"},
+		{"'quoted'", "quoted"},
+		{"", "Sheet"},
+		{"a very long test case folder name over the limit", "a very long test case folder na"},
+		{"テストケース", "テストケース"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeSheetName(tt.name, Options{}); got != tt.want {
+			t.Errorf("sanitizeSheetName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInsertSplitByFolderSheetCollision(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testImages, "img1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, names := range [][2]string{
+		{"run[1]", "run]1["},
+		{"a very long test case folder name one", "a very long test case folder name two"},
+	} {
+		root := t.TempDir()
+		for _, name := range names {
+			if err := os.Mkdir(filepath.Join(root, name), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, name, "1.png"), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		opts := testOptions()
+		opts.FolderPath, opts.SheetName, opts.SplitByFolder = root, "", true
+		opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+		if err := Insert(opts); err == nil || !strings.Contains(err.Error(), "both become sheet") {
+			t.Errorf("%v: expected a sheet name collision error, got %v", names, err)
+		}
+	}
+}
+
+func TestInsertContinueOnError(t *testing.T) {
+	folder := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(testImages, "img1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{"1.png": data, "2.png": []byte("corrupt"), "3.png": data} {
+		if err := os.WriteFile(filepath.Join(folder, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := testOptions()
+	opts.FolderPath = folder
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	opts.ContinueOnError = true
+	var skipped []Skip
+	opts.OnSkip = func(s Skip) { skipped = append(skipped, s) }
+	var progress bytes.Buffer
+	opts.Progress = &progress
+	err = Insert(opts)
+	if !errors.Is(err, ErrSkipped) || !strings.Contains(err.Error(), "2.png") {
+		t.Fatalf("Insert error = %v, want the corrupt image reported", err)
+	}
+	if len(skipped) != 1 || filepath.Base(skipped[0].Path) != "2.png" || skipped[0].Error == "" {
+		t.Errorf("skipped = %+v, want 2.png with its error", skipped)
+	}
+	if !strings.HasSuffix(progress.String(), testSheet+": 2/2 images\n") {
+		t.Errorf("progress = %q, want it to end at 2/2", progress.String())
+	}
+	// In stream mode the image is skipped while placing, and still counts so
+	// the count completes its line
+	progress.Reset()
+	streamOpts := opts
+	streamOpts.Stream, streamOpts.OnSkip, streamOpts.Meta = true, nil, true
+	streamOpts.OutPath = filepath.Join(t.TempDir(), "stream.xlsx")
+	if err := Insert(streamOpts); !errors.Is(err, ErrSkipped) {
+		t.Fatalf("Insert error in stream mode = %v, want the corrupt image reported", err)
+	}
+	if !strings.HasSuffix(progress.String(), testSheet+": 3/3 images\n") {
+		t.Errorf("stream progress = %q, want it to end at 3/3", progress.String())
+	}
+	// The metadata counts the images inserted, not those found
+	stream, err := excelize.OpenFile(streamOpts.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	col, row, _ := excelize.CellNameToCoordinates(opts.StartCell)
+	countCell, _ := excelize.CoordinatesToCellName(col, row+3)
+	if got, _ := stream.GetCellValue(testSheet, countCell); got != "Images: 2" {
+		t.Errorf("metadata %s = %q, want Images: 2", countCell, got)
+	}
+
+	out, err := excelize.OpenFile(opts.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if cells, err := out.GetPictureCells(testSheet); err != nil || len(cells) != 2 {
+		t.Errorf("picture cells = %v (%v), want the two readable images", cells, err)
+	}
+}
+
+func TestGetImageFilesStdin(t *testing.T) {
+	opts := testOptions()
+	list := filepath.Join(testImages, "img20.png") + "\n\n" + filepath.ToSlash(filepath.Join(testImages, "cover.png")) + "\r\n"
+	opts.Input = strings.NewReader(list)
+	images, err := getImageFiles(StdinFolder, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, img := range images {
+		names = append(names, filepath.Base(img.FilePath))
+	}
+	if want := []string{"img20.png", "cover.png"}; !slices.Equal(names, want) {
+		t.Errorf("stdin images = %v, want %v", names, want)
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := parseIgnoreRules(strings.NewReader(`
+# debug captures
+debug_*.png
+!debug_keep.png
+tmp/
+/draft.png
+**/raw/*.jpg
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		relPath string
+		ignored bool
+	}{
+		{"login.png", false},
+		{"debug_1.png", true},
+		{"cart/debug_2.png", true},
+		{"debug_keep.png", false},
+		{"tmp/a.png", true},
+		{"cart/tmp/b.png", true},
+		{"tmp.png", false},
+		{"draft.png", true},
+		{"cart/draft.png", false},
+		{"cart/raw/c.jpg", true},
+		{"cart/raw/c.png", false},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(filepath.FromSlash(tt.relPath)); got != tt.ignored {
+			t.Errorf("ignored(%q) = %v, want %v", tt.relPath, got, tt.ignored)
+		}
+	}
+}
+
+func TestGroupStarts(t *testing.T) {
+	var images []ImageInfo
+	for _, name := range []string{"login_1.png", "login_2.png", "cart-1.png", "cart-2.png", "cover.png"} {
+		images = append(images, ImageInfo{FilePath: filepath.Join("shots", name)})
+	}
+	opts := testOptions()
+	opts.GroupByPrefix = true
+	opts.GroupDelimiters = "_-"
+	if got, want := groupStarts(images, opts), []bool{true, false, true, false, true}; !slices.Equal(got, want) {
+		t.Errorf("group starts = %v, want %v", got, want)
+	}
+	if got := groupPrefix(images[2], opts.GroupDelimiters); got != "cart" {
+		t.Errorf("group prefix = %q, want cart", got)
+	}
+	opts.GroupByPrefix = false
+	if got := groupStarts(images, opts); slices.Contains(got, true) {
+		t.Errorf("group starts without grouping = %v, want none", got)
+	}
+}
+
+func TestGIFFrame(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	first := image.NewPaletted(image.Rect(0, 0, 4, 2), palette)
+	// The second frame only repaints the right half white
+	second := image.NewPaletted(image.Rect(2, 0, 4, 2), palette)
+	draw.Draw(second, second.Bounds(), image.White, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	anim := &gif.GIF{Image: []*image.Paletted{first, second}, Delay: []int{10, 10}}
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		index int
+		right color.Color
+	}{{0, color.Black}, {1, color.White}, {5, color.White}} {
+		data, err := gifFrame(buf.Bytes(), tt.index, Options{})
+		if err != nil {
+			t.Fatalf("frame %d: %v", tt.index, err)
+		}
+		frame, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("frame %d: %v", tt.index, err)
+		}
+		if got := color.GrayModel.Convert(frame.At(0, 0)); got != color.GrayModel.Convert(color.Black) {
+			t.Errorf("frame %d: left pixel = %v, want black", tt.index, got)
+		}
+		if got, want := color.GrayModel.Convert(frame.At(3, 1)), color.GrayModel.Convert(tt.right); got != want {
+			t.Errorf("frame %d: right pixel = %v, want %v", tt.index, got, want)
+		}
+	}
+}
+
+func TestInsertStream(t *testing.T) {
+	opts := testOptions()
+	opts.Stream = true
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	// Streaming only changes when images are read, not where they go
+	if breaks, want := columnBreaks(t, opts.OutPath), []int{37, 74, 111, 148}; !slices.Equal(breaks, want) {
+		t.Errorf("column breaks = %v, want %v", breaks, want)
+	}
+}
+
+func TestInsertTemplateSheet(t *testing.T) {
+	opts := testOptions()
+	opts.TemplateSheet, opts.SheetName = testSheet, "Run 2"
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(opts.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if !slices.Contains(f.GetSheetList(), testSheet) {
+		t.Errorf("template sheet %q was removed", testSheet)
+	}
+	pics, err := f.GetPictureCells("Run 2")
+	if err != nil || len(pics) == 0 {
+		t.Errorf("no pictures in copied sheet: %v", err)
+	}
+
+	// Copying into an existing sheet would overwrite an earlier run
+	opts.TemplatePath, opts.SheetName = opts.OutPath, "Run 2"
+	if err := Insert(opts); err == nil {
+		t.Error("expected an error for an existing target sheet")
+	}
+}
+
+func TestReadPlacements(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "placement.csv")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	images, err := readPlacements(testImages, write("cell,filename\nh20,img2.jpg\nB4,cover.png\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := []string{images[0].Cell, images[1].Cell}; !slices.Equal(got, []string{"H20", "B4"}) {
+		t.Errorf("cells = %v, want [H20 B4]", got)
+	}
+
+	for _, content := range []string{"4B,img2.jpg\n", "B4,img2.jpg\nb4,cover.png\n", "B4,missing.png\n"} {
+		if _, err := readPlacements(testImages, write(content)); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestAddBorder(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	opts := testOptions()
+	// The template's fill and number format survive the border
+	fill, err := f.NewStyle(&excelize.Style{
+		Fill:   excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFFF00"}},
+		NumFmt: 14,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetCellStyle("Sheet1", "B2", "B2", fill); err != nil {
+		t.Fatal(err)
+	}
+	// Default columns are 64 pixels and rows 20 pixels, so 100x30 covers B2:C3
+	if err := addBorder(f, "Sheet1", "B2", 100, 30, opts); err != nil {
+		t.Fatal(err)
+	}
+	for cell, want := range map[string][]string{
+		"B2": {"left", "top"},
+		"C2": {"right", "top"},
+		"B3": {"left", "bottom"},
+		"C3": {"right", "bottom"},
+		"D2": nil,
+	} {
+		id, err := f.GetCellStyle("Sheet1", cell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		style, err := f.GetStyle(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sides []string
+		for _, border := range style.Border {
+			sides = append(sides, border.Type)
+		}
+		if !slices.Equal(sides, want) {
+			t.Errorf("%s borders = %v, want %v", cell, sides, want)
+		}
+		if cell == "B2" && (!slices.Equal(style.Fill.Color, []string{"FFFF00"}) || style.NumFmt != 14) {
+			t.Errorf("B2 lost its fill or number format: %+v", style)
+		}
+	}
+}
+
+func TestRowLength(t *testing.T) {
+	images := make([]ImageInfo, 5)
+	groups := []bool{true, false, false, true, false}
+	for _, tt := range []struct{ start, cols, want int }{
+		{0, 2, 2}, // Full row
+		{0, 4, 3}, // Ends before the next group
+		{3, 4, 2}, // Ends with the images
+	} {
+		if got := rowLength(images[tt.start:], groups[tt.start:], tt.cols); got != tt.want {
+			t.Errorf("rowLength from %d with %d columns = %d, want %d", tt.start, tt.cols, got, tt.want)
+		}
+	}
+}
+
+func TestExactScale(t *testing.T) {
+	// 10 cm at 96 pixels per inch is 377.95 pixels, which rounds up to 378
+	target := 10 * pixelsPerCM
+	for _, size := range []int{16, 300, 1234, 4000} {
+		if got := int(float64(size) * exactScale(target/float64(size), size)); got != 378 {
+			t.Errorf("size %d scales to %d pixels, want 378", size, got)
+		}
+	}
+}
+
+func TestCaptionAbove(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	opts := testOptions()
+	opts.Caption, opts.CaptionPosition, opts.CaptionOffset = true, "above", 2
+	img := ImageInfo{FilePath: filepath.Join(testImages, "cover.png"), RelPath: "cover.png"}
+	if err := placeImage(f, "Sheet1", img, "B4", opts); err != nil {
+		t.Fatal(err)
+	}
+	if caption, _ := f.GetCellValue("Sheet1", "B4"); caption != "cover" {
+		t.Errorf("caption at B4 = %q, want cover", caption)
+	}
+	if pics, err := f.GetPictures("Sheet1", "B6"); err != nil || len(pics) != 1 {
+		t.Errorf("got %d pictures at B6 (%v), want the image moved below its caption", len(pics), err)
+	}
+}
+
+func TestInsertSheetIndex(t *testing.T) {
+	opts := testOptions()
+	opts.SheetName, opts.SheetIndex = "", 0
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	if breaks, want := columnBreaks(t, opts.OutPath), []int{37, 74, 111, 148}; !slices.Equal(breaks, want) {
+		t.Errorf("column breaks = %v, want %v", breaks, want)
+	}
+
+	opts.SheetIndex = 1
+	if err := Insert(opts); err == nil {
+		t.Error("expected an error for a sheet index past the last sheet")
+	}
+}
+
+func TestInsertDedupe(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"cover.png", "img2.jpg"} {
+		data, err := os.ReadFile(filepath.Join(testImages, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, copyName := range []string{"a_" + name, "b_" + name} {
+			if err := os.WriteFile(filepath.Join(dir, copyName), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, stream := range []bool{false, true} {
+		opts := testOptions()
+		opts.FolderPath, opts.DryRun, opts.Dedupe, opts.Stream = dir, true, true, stream
+		opts.Output, opts.Warnings = io.Discard, io.Discard
+		var placed []string
+		opts.OnPlace = func(p Placement) { placed = append(placed, filepath.Base(p.Path)) }
+		if err := Insert(opts); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"a_cover.png", "a_img2.jpg"}; !slices.Equal(placed, want) {
+			t.Errorf("stream %v: placed %v, want %v", stream, placed, want)
+		}
+	}
+}
+
+func TestSetFooter(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	opts := testOptions()
+	opts.PageNumbers, opts.FooterLeft, opts.FooterCenter = true, "Login tests", "Page"
+	if err := setFooter(f, "Sheet1", opts); err != nil {
+		t.Fatal(err)
+	}
+	hf, err := f.GetHeaderFooter("Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "&LLogin tests&CPage &P of &N"; hf.OddFooter != want {
+		t.Errorf("footer = %q, want %q", hf.OddFooter, want)
+	}
+}
+
+func TestInsertMaxPerSheet(t *testing.T) {
+	opts := testOptions()
+	opts.MaxPerSheet = 2
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	var placed []string
+	opts.OnPlace = func(p Placement) { placed = append(placed, p.Sheet+"!"+p.Cell) }
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	// Each sheet starts its layout again at the start cell
+	want := []string{testSheet + "!B4", testSheet + "!AM4", testSheet + "_2!B4", testSheet + "_2!AM4", testSheet + "_3!B4"}
+	if !slices.Equal(placed, want) {
+		t.Errorf("placed at %v, want %v", placed, want)
+	}
+}
+
+func TestPlaceImageAutoFit(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	opts := testOptions()
+	opts.AutoFit = true
+	var placement Placement
+	opts.OnPlace = func(p Placement) { placement = p }
+	img := ImageInfo{FilePath: filepath.Join(testImages, "cover.png"), RelPath: "cover.png"}
+	if err := placeImage(f, "Sheet1", img, "B4", opts); err != nil {
+		t.Fatal(err)
+	}
+	// excelize sizes the image from its cell, so it is not scaled first
+	if placement.ScaleX != 1 || placement.ScaleY != 1 {
+		t.Errorf("scale = %g x %g, want 1 x 1", placement.ScaleX, placement.ScaleY)
+	}
+	pics, err := f.GetPictures("Sheet1", "B4")
+	if err != nil || len(pics) != 1 {
+		t.Errorf("got %d pictures at B4 (%v), want 1", len(pics), err)
+	}
+}
+
+func TestFlattenImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.NRGBA{A: 0xff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	flat, err := flattenImage(buf.Bytes(), "#FF0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(flat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, a := img.At(1, 0).RGBA(); r != 0xffff || g != 0 || b != 0 || a != 0xffff {
+		t.Errorf("transparent pixel = %v, want opaque red", img.At(1, 0))
+	}
+
+	// Opaque images are left alone
+	if flat, err := flattenImage(flat, "FFFFFF"); err != nil || flat != nil {
+		t.Errorf("flattenImage of an opaque image = %d bytes, %v, want nil", len(flat), err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	opts := testOptions()
+	var out bytes.Buffer
+	opts.Output = &out
+	if err := Check(opts); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Sheet Evidence: images start at B4") {
+		t.Errorf("output = %q, want the resolved start cell", out.String())
+	}
+
+	opts.SheetName = "Missing"
+	if err := Check(opts); err == nil {
+		t.Error("expected an error for a missing sheet")
+	}
+	opts.CreateSheet = true
+	if err := Check(opts); err != nil {
+		t.Errorf("Check with -create-sheet: %v", err)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	opts := testOptions()
+	opts.SheetName = "Missing"
+	if err := Insert(opts); !errors.Is(err, ErrSheetNotFound) {
+		t.Errorf("Insert into a missing sheet = %v, want ErrSheetNotFound", err)
+	}
+
+	img := ImageInfo{FilePath: filepath.Join(testImages, "notes.txt")}
+	if err := loadImage(&img, testOptions()); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("loadImage of a text file = %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestColumnsFromTemplate(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	if err := f.SetColWidth("Sheet1", "B", "B", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetColWidth("Sheet1", "C", "C", 40); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.ColumnsFromTemplate, opts.ColStep, opts.PageBreak = true, 0, "none"
+	var placed []Placement
+	opts.OnPlace = func(p Placement) { placed = append(placed, p) }
+	images := []ImageInfo{
+		{FilePath: filepath.Join(testImages, "cover.png")},
+		{FilePath: filepath.Join(testImages, "img1.png")},
+	}
+	if err := pasteImagesHorizontally(f, "Sheet1", images, opts); err != nil {
+		t.Fatal(err)
+	}
+	// The 16 pixel wide images fill columns of 145 and 285 pixels
+	for i, want := range []struct {
+		cell  string
+		scale float64
+	}{{"B4", 145.0 / 16}, {"C4", 285.0 / 16}} {
+		if placed[i].Cell != want.cell || placed[i].ScaleX != want.scale || placed[i].ScaleY != want.scale {
+			t.Errorf("image %d placed at %s with scale %g x %g, want %s with %g", i, placed[i].Cell, placed[i].ScaleX, placed[i].ScaleY, want.cell, want.scale)
+		}
+	}
+}
+
+func TestInsertCountCell(t *testing.T) {
+	opts := testOptions()
+	opts.CountCell = "A2"
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(opts.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if count, _ := f.GetCellValue(testSheet, "A2"); count != "5" {
+		t.Errorf("count cell = %q, want 5", count)
+	}
+
+	opts.CountCell = "NoSuchName"
+	if err := Insert(opts); err == nil {
+		t.Error("expected an error for an invalid count cell")
+	}
+}
+
+func TestInsertFilesConcurrent(t *testing.T) {
+	root := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(testImages, "img1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := 1; i <= 6; i++ {
+		sub := fmt.Sprintf("run%d", i)
+		if err := os.Mkdir(filepath.Join(root, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a.png", "b.png"} {
+			if err := os.WriteFile(filepath.Join(root, sub, name), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, filepath.Join(sub, name))
+		}
+	}
+	opts := testOptions()
+	opts.FolderPath, opts.SheetName = root, ""
+	opts.SplitByFolder, opts.SplitFiles, opts.Jobs = true, true, 4
+	opts.OutPath = t.TempDir()
+	var placed []string
+	opts.OnPlace = func(p Placement) {
+		rel, _ := filepath.Rel(root, p.Path)
+		placed = append(placed, rel)
+	}
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	// Placements are reported in folder order whichever workbook finishes first
+	if !slices.Equal(placed, want) {
+		t.Errorf("placed %v, want %v", placed, want)
+	}
+	for i := 1; i <= 6; i++ {
+		if _, err := os.Stat(filepath.Join(opts.OutPath, fmt.Sprintf("run%d.xlsx", i))); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSidecarScale(t *testing.T) {
+	dir := t.TempDir()
+	img := ImageInfo{FilePath: filepath.Join(dir, "1.png")}
+	if _, ok, err := sidecarScale(img); ok || err != nil {
+		t.Errorf("sidecarScale without a sidecar = %v, %v, want none", ok, err)
+	}
+	for content, want := range map[string]float64{"0.5\n": 0.5, "0": 0, "big": 0} {
+		if err := os.WriteFile(img.FilePath+".scale", []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		scale, ok, err := sidecarScale(img)
+		if want > 0 && (scale != want || !ok || err != nil) {
+			t.Errorf("sidecarScale(%q) = %g, %v, %v, want %g", content, scale, ok, err, want)
+		}
+		if want == 0 && err == nil {
+			t.Errorf("sidecarScale(%q) = %g, want an error", content, scale)
+		}
+	}
+}
+
+func TestLoadImageRotate(t *testing.T) {
+	opts := testOptions()
+	opts.Rotate = 90
+	img := ImageInfo{FilePath: filepath.Join(testImages, "cover.png")}
+	if err := loadImage(&img, opts); err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 9 || img.Height != 16 {
+		t.Errorf("rotated 16x9 image is %dx%d, want 9x16", img.Width, img.Height)
+	}
+}
+
+func TestOpenEncryptedTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.xlsx")
+	f := excelize.NewFile()
+	if err := f.SaveAs(path, excelize.Options{Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for _, password := range []string{"", "wrong"} {
+		if _, err := openExcelFile(path, password); !errors.Is(err, ErrPasswordProtected) {
+			t.Errorf("openExcelFile with password %q = %v, want ErrPasswordProtected", password, err)
+		}
+	}
+
+	// The workbook saved from an encrypted template stays encrypted
+	template, err := openExcelFile(testTemplate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := template.SaveAs(path, excelize.Options{Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	template.Close()
+	opts := testOptions()
+	opts.TemplatePath, opts.Password = path, "secret"
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openExcelFile(opts.OutPath, ""); !errors.Is(err, ErrPasswordProtected) {
+		t.Errorf("opening the output without a password = %v, want ErrPasswordProtected", err)
+	}
+}
+
+func TestPasteImagesIntoTable(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	if err := f.AddTable("Sheet1", &excelize.Table{Range: "A1:B4", Name: "Steps"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetRowHeight("Sheet1", 3, 54); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.Table, opts.StartCell = "Steps", "C1"
+	var placed []Placement
+	opts.OnPlace = func(p Placement) { placed = append(placed, p) }
+	images := []ImageInfo{
+		{FilePath: filepath.Join(testImages, "cover.png")},
+		{FilePath: filepath.Join(testImages, "img1.png")},
+	}
+	if err := pasteImages(f, "Sheet1", images, opts); err != nil {
+		t.Fatal(err)
+	}
+	// The 9 pixel high images fill rows of 20 and 72 pixels below the header
+	for i, want := range []struct {
+		cell  string
+		scale float64
+	}{{"C2", 20.0 / 9}, {"C3", 72.0 / 9}} {
+		if placed[i].Cell != want.cell || math.Abs(placed[i].ScaleY-want.scale) > 1e-9 {
+			t.Errorf("image %d placed at %s with scale %g, want %s with %g", i, placed[i].Cell, placed[i].ScaleY, want.cell, want.scale)
+		}
+	}
+
+	images = append(images, images...)
+	if err := pasteImages(f, "Sheet1", images, opts); err == nil {
+		t.Error("expected an error for more images than table rows")
+	}
+}
+
+func TestList(t *testing.T) {
+	var out bytes.Buffer
+	opts := testOptions()
+	opts.Output = &out
+	opts.Skip, opts.Limit = 1, 2
+	if err := List(opts); err != nil {
+		t.Fatal(err)
+	}
+	images, err := getImageFiles(testImages, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ""
+	for _, image := range images {
+		want += image.FilePath + "\n"
+	}
+	if len(images) != 2 || out.String() != want {
+		t.Errorf("List printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestRemoteImages(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir(testImages)))
+	defer server.Close()
+
+	list := server.URL + "/cover.png\n" + server.URL + "/missing.png\n"
+	opts := testOptions()
+	opts.Input = strings.NewReader(list)
+	images, err := getImageFiles(StdinFolder, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].FilePath != server.URL+"/cover.png" {
+		t.Fatalf("getImageFiles returned %v, want the two URLs", relPaths(images))
+	}
+
+	if err := loadImage(&images[0], opts); err != nil {
+		t.Fatal(err)
+	}
+	if images[0].Width == 0 || images[0].Data == nil {
+		t.Errorf("fetched image was not decoded: %dx%d", images[0].Width, images[0].Height)
+	}
+	err = loadImage(&images[1], opts)
+	if err == nil || !strings.Contains(err.Error(), server.URL+"/missing.png: 404") {
+		t.Errorf("expected a 404 error naming the URL, got %v", err)
+	}
+
+	// Downloads stop at MaxFileSize, with or without a Content-Length
+	data, err := os.ReadFile(filepath.Join(testImages, "img2.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		w.Write(data)
+	}))
+	defer streamed.Close()
+	opts.MaxFileSize = 100.0 / (1 << 20)
+	for _, url := range []string{server.URL + "/img2.jpg", streamed.URL + "/img2.jpg"} {
+		img := ImageInfo{FilePath: url, RelPath: url}
+		if err := loadImage(&img, opts); err == nil || !strings.Contains(err.Error(), "larger than") {
+			t.Errorf("loadImage(%s) = %v, want a size error", url, err)
+		}
+	}
+}
+
+func TestAddComment(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	img := ImageInfo{RelPath: filepath.Join("login", "1.png"), Width: 800, Height: 600, Caption: "Login page"}
+	if err := addComment(f, "Sheet1", "B4", img); err != nil {
+		t.Fatal(err)
+	}
+	comments, err := f.GetComments("Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "login/1.png\n800x600 px\nLogin page"
+	if len(comments) != 1 || comments[0].Cell != "B4" || comments[0].Text != want {
+		t.Errorf("comments = %+v, want one at B4 reading %q", comments, want)
+	}
+}
+
+func TestGetImageFilesEmpty(t *testing.T) {
+	var out bytes.Buffer
+	opts := testOptions()
+	opts.Warnings = &out
+	images, err := getImageFiles("testdata/truncated", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relPaths(images); !slices.Equal(got, []string{"1.png"}) {
+		t.Errorf("images = %v, want the empty 2.png skipped", got)
+	}
+	if !strings.Contains(out.String(), "2.png, the file is empty") {
+		t.Errorf("expected a warning about 2.png, got %q", out.String())
+	}
+
+	// Listed images are skipped the same way
+	manifest := filepath.Join(t.TempDir(), "manifest.csv")
+	if err := os.WriteFile(manifest, []byte("1,1.png\n2,2.png\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	listOpts := opts
+	listOpts.Manifest = manifest
+	if images, err := getImageFiles("testdata/truncated", listOpts); err != nil || !slices.Equal(relPaths(images), []string{"1.png"}) {
+		t.Errorf("manifest images = %v, %v, want the empty 2.png skipped", relPaths(images), err)
+	}
+	listOpts = opts
+	listOpts.Input = strings.NewReader(filepath.Join("testdata", "truncated", "2.png") + "\n")
+	if images, err := getImageFiles(StdinFolder, listOpts); err != nil || len(images) != 0 {
+		t.Errorf("stdin images = %v, %v, want the empty 2.png skipped", relPaths(images), err)
+	}
+
+	opts.FailFast = true
+	if _, err := getImageFiles("testdata/truncated", opts); err == nil {
+		t.Error("expected an error for the empty file with FailFast")
+	}
+}
+
+func TestInsertCover(t *testing.T) {
+	cover := excelize.NewFile()
+	cover.SetSheetName("Sheet1", "Cover")
+	cover.SetCellValue("Cover", "A1", "{{TITLE}}")
+	cover.SetCellValue("Cover", "A2", "{{COUNT}} images, {{DATE}}")
+	cover.SetCellValue("Cover", "B3", 42)
+	cover.MergeCell("Cover", "A1", "C1")
+	bold, _ := cover.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	cover.SetCellStyle("Cover", "A1", "A1", bold)
+	coverPath := filepath.Join(t.TempDir(), "cover.xlsx")
+	if err := cover.SaveAs(coverPath); err != nil {
+		t.Fatal(err)
+	}
+	cover.Close()
+
+	opts := testOptions()
+	opts.Cover, opts.Title = coverPath, "Release 1.2"
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(opts.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if sheets := f.GetSheetList(); sheets[0] != "Cover" || f.GetActiveSheetIndex() != 0 {
+		t.Fatalf("sheets = %v with active %d, want Cover first and active", sheets, f.GetActiveSheetIndex())
+	}
+	want := map[string]string{"A1": "Release 1.2", "A2": "5 images, " + time.Now().Format("2006-01-02"), "B3": "42"}
+	for cell, value := range want {
+		if got, _ := f.GetCellValue("Cover", cell); got != value {
+			t.Errorf("cover %s = %q, want %q", cell, got, value)
+		}
+	}
+	// Numbers stay numbers rather than becoming text
+	if cellType, _ := f.GetCellType("Cover", "B3"); cellType != excelize.CellTypeUnset && cellType != excelize.CellTypeNumber {
+		t.Errorf("cover B3 type = %v, want a number", cellType)
+	}
+	if merges, _ := f.GetMergeCells("Cover"); len(merges) != 1 || merges[0].GetEndAxis() != "C1" {
+		t.Errorf("cover merged cells = %v, want A1:C1", merges)
+	}
+	id, _ := f.GetCellStyle("Cover", "A1")
+	if style, err := f.GetStyle(id); err != nil || style.Font == nil || !style.Font.Bold {
+		t.Errorf("cover A1 is not bold: %+v", style)
+	}
+
+	// A second run would copy the cover onto a workbook that already has it
+	opts.TemplatePath = opts.OutPath
+	if err := Insert(opts); err == nil {
+		t.Error("expected an error for an existing cover sheet")
+	}
+}
+
+func TestInsertAppend(t *testing.T) {
+	opts := testOptions()
+	opts.Append, opts.Limit = true, 2
+	opts.OutPath = filepath.Join(t.TempDir(), "out.xlsx")
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	// The second run continues one column step after the first
+	opts.TemplatePath = opts.OutPath
+	if err := Insert(opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(opts.OutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cells, err := f.GetPictureCells(testSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		ci, _, _ := excelize.CellNameToCoordinates(cells[i])
+		cj, _, _ := excelize.CellNameToCoordinates(cells[j])
+		return ci < cj
+	})
+	if want := []string{"B4", "AM4", "BX4", "DI4"}; !slices.Equal(cells, want) {
+		t.Errorf("picture cells = %v, want %v", cells, want)
+	}
+}
+
+func TestGetImageFilesMaxFileSize(t *testing.T) {
+	var out bytes.Buffer
+	opts := testOptions()
+	opts.Warnings = &out
+	// 100 bytes keeps the 84 byte PNGs and drops the 626 byte JPEG
+	opts.MaxFileSize = 100.0 / (1 << 20)
+	images, err := getImageFiles(testImages, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relPaths(images); slices.Contains(got, "img2.jpg") || len(got) != 4 {
+		t.Errorf("images = %v, want the PNGs only", got)
+	}
+	if !strings.Contains(out.String(), "img2.jpg, the file is larger than") {
+		t.Errorf("expected a warning about img2.jpg, got %q", out.String())
+	}
+
+	// The listed paths stay free of warnings
+	var list bytes.Buffer
+	opts.Output = &list
+	if err := List(opts); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(list.String(), "Warning") {
+		t.Errorf("list output = %q, want the warning on the warnings writer", list.String())
+	}
+
+	opts.FailFast = true
+	if _, err := getImageFiles(testImages, opts); err == nil {
+		t.Error("expected an error for the oversized file with FailFast")
+	}
+}
+
+func TestPasteImagesSpacing(t *testing.T) {
+	encode := func(w, h int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	wide, tall := encode(200, 100), encode(100, 200)
+	images := []ImageInfo{
+		{FilePath: "wide1.png", Source: wide},
+		{FilePath: "tall.png", Source: tall},
+		{FilePath: "wide2.png", Source: wide},
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	opts := testOptions()
+	opts.DryRun, opts.Output = true, io.Discard
+	opts.StartCell, opts.ColStep, opts.PageBreak = "A1", 0, "none"
+	// The default 69 pixel columns fit the wide images in 2 and the tall one in 1
+	opts.Width, opts.Height, opts.PreserveAspect, opts.Spacing = 138, 138, true, 1
+	var cells []string
+	opts.OnPlace = func(p Placement) { cells = append(cells, p.Cell) }
+	if err := pasteImages(f, "Sheet1", images, opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A1", "C1", "D1"}; !slices.Equal(cells, want) {
+		t.Errorf("cells = %v, want %v", cells, want)
+	}
+}
+
+func TestInsertBytes(t *testing.T) {
+	before, err := os.ReadFile(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := InsertBytes(testOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if cells, err := f.GetPictureCells(testSheet); err != nil || len(cells) != 5 {
+		t.Errorf("got %d pictures (%v), want 5", len(cells), err)
+	}
+	if after, err := os.ReadFile(testTemplate); err != nil || !bytes.Equal(before, after) {
+		t.Error("the template was modified")
+	}
+}
+
+func TestGetImageFilesTimeWindow(t *testing.T) {
+	folder := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(testImages, "img1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"1.png", "2.png", "3.png"} {
+		path := filepath.Join(folder, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := day.AddDate(0, 0, i)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		since, until time.Time
+		want         []string
+	}{
+		{want: []string{"1.png", "2.png", "3.png"}},
+		{since: day.AddDate(0, 0, 1), want: []string{"2.png", "3.png"}},
+		{until: day.AddDate(0, 0, 1), want: []string{"1.png", "2.png"}},
+		{since: day.Add(time.Hour), until: day.AddDate(0, 0, 2).Add(-time.Hour), want: []string{"2.png"}},
+	}
+	for _, tt := range tests {
+		opts := testOptions()
+		opts.Since, opts.Until = tt.since, tt.until
+		images, err := getImageFiles(folder, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := relPaths(images); !slices.Equal(got, tt.want) {
+			t.Errorf("since %v until %v: images = %v, want %v", tt.since, tt.until, got, tt.want)
+		}
+	}
+}
+
+func TestPasteImagesHorizontallyPageBreaks(t *testing.T) {
+	// A blank workbook, as the template already has breaks at the default step
+	f := excelize.NewFile()
+	opts := testOptions()
+	opts.StartCell, opts.ColStep = "B4", 10
+	images := []ImageInfo{
+		{FilePath: filepath.Join(testImages, "img1.png")},
+		{FilePath: filepath.Join(testImages, "img10.png")},
+		{FilePath: filepath.Join(testImages, "img20.png")},
+	}
+	if err := pasteImages(f, "Sheet1", images, opts); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "breaks.xlsx")
+	if err := f.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// The images at B, L and V get pages starting one column before them,
+	// like the first page does before B, and no break after the last one
+	if breaks, want := columnBreaks(t, path), []int{10, 20}; !slices.Equal(breaks, want) {
+		t.Errorf("column breaks = %v, want %v", breaks, want)
+	}
+}
+
+// Run with -race: the warnings of concurrently loaded images must not be
+// written to the shared output from the workers
+func TestLoadImagesWarnPixels(t *testing.T) {
+	var out bytes.Buffer
+	opts := testOptions()
+	opts.Warnings, opts.Jobs, opts.WarnPixels = &out, 8, 1
+	var images []ImageInfo
+	for range 8 {
+		found, err := getImageFiles(testImages, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, found...)
+	}
+	images, err := loadImages(images, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One line per image, in list order
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(images) {
+		t.Fatalf("got %d warnings for %d images", len(lines), len(images))
+	}
+	for i, img := range images {
+		if !strings.HasPrefix(lines[i], "Warning: "+img.FilePath+" is ") {
+			t.Errorf("warning %d = %q, want one about %s", i, lines[i], img.FilePath)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package evidence
+
+import (
+	"fmt"
+	"strings"
+)
+
+// failures collects the images skipped because they could not be read or
+// inserted
+type failures struct {
+	errs []string
+}
+
+// add records that the image was skipped because of err
+func (f *failures) add(img ImageInfo, err error, opts Options) {
+	opts.logf("Skipping %s: %v", img.FilePath, err)
+	f.errs = append(f.errs, fmt.Sprintf("%s: %v", img.FilePath, err))
+	opts.skip(Skip{Path: img.FilePath, Error: err.Error()})
+}
+
+// count returns the number of images skipped so far
+func (f *failures) count() int {
+	if f == nil {
+		return 0
+	}
+	return len(f.errs)
+}
+
+// err returns an error of kind ErrSkipped summarizing the skipped images, or
+// nil when none was skipped or failures are not being collected
+func (f *failures) err() error {
+	if f == nil || len(f.errs) == 0 {
+		return nil
+	}
+	return errorf(ErrSkipped, "Skipped %d images that could not be inserted:\n  %s", len(f.errs), strings.Join(f.errs, "\n  "))
+}
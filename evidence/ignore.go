@@ -0,0 +1,103 @@
+package evidence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile is the name of the file listing the images to leave out of a
+// folder, one gitignore-style pattern per line
+const ignoreFile = ".evidenceignore"
+
+// ignoreRule is a single pattern of an ignore file
+type ignoreRule struct {
+	pattern  []string // Slash-separated segments of the pattern
+	negate   bool     // The pattern starts with ! and re-includes matches
+	dirOnly  bool     // The pattern ends with / and only matches folders
+	anchored bool     // The pattern contains a / and matches from the folder root
+}
+
+// ignoreRules holds the rules of an ignore file in order. Later rules take
+// precedence over earlier ones, as in .gitignore.
+type ignoreRules []ignoreRule
+
+// readIgnoreFile reads the ignore file of the folder, if there is one
+func readIgnoreFile(folderPath string) (ignoreRules, error) {
+	file, err := os.Open(filepath.Join(folderPath, ignoreFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", ignoreFile, err)
+	}
+	defer file.Close()
+	return parseIgnoreRules(file)
+}
+
+// parseIgnoreRules parses gitignore-style patterns. Blank lines and lines
+// starting with # are skipped.
+func parseIgnoreRules(r io.Reader) (ignoreRules, error) {
+	var rules ignoreRules
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if rule.negate = strings.HasPrefix(text, "!"); rule.negate {
+			text = text[1:]
+		}
+		if rule.dirOnly = strings.HasSuffix(text, "/"); rule.dirOnly {
+			text = strings.TrimSuffix(text, "/")
+		}
+		rule.anchored = strings.Contains(text, "/")
+		rule.pattern = strings.Split(strings.TrimPrefix(text, "/"), "/")
+		if _, err := matchSegments(rule.pattern, []string{"x"}); err != nil {
+			return nil, fmt.Errorf("%s line %d: invalid pattern %q: %w", ignoreFile, line, text, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ignoreFile, err)
+	}
+	return rules, nil
+}
+
+// ignored reports whether the file at relPath, or any folder containing it,
+// is excluded by the rules
+func (rules ignoreRules) ignored(relPath string) bool {
+	segments := strings.Split(filepath.ToSlash(relPath), "/")
+	for i := 1; i <= len(segments); i++ {
+		if rules.match(segments[:i], i < len(segments)) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the rules to a single file or folder path
+func (rules ignoreRules) match(segments []string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		var matched bool
+		if rule.anchored {
+			matched, _ = matchSegments(rule.pattern, segments)
+		} else {
+			matched, _ = path.Match(rule.pattern[0], segments[len(segments)-1])
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
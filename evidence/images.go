@@ -0,0 +1,576 @@
+package evidence
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// StdinFolder is the folder path that reads the image paths from the input
+// instead of walking a folder
+const StdinFolder = "-"
+
+// DefaultExtensions lists the image file extensions accepted by default
+const DefaultExtensions = "png,jpg,jpeg,bmp,webp,tif,tiff,gif,svg"
+
+// numberPattern matches a run of digits in a filename
+var numberPattern = regexp.MustCompile(`\d+`)
+
+// ImageInfo holds image file details
+type ImageInfo struct {
+	FilePath string      // Path used to read the image
+	RelPath  string      // Path relative to the image folder
+	Info     os.FileInfo // File details collected during the walk
+	Caption  string      // Caption text, defaults to the file name when empty
+	Source   []byte      // Original bytes when not read from FilePath, e.g. a ZIP entry
+	Cell     string      // Cell the image is placed at instead of following the layout, if set
+
+	Data      []byte // Image bytes ready to embed, filled in by loadImages
+	Extension string // Extension matching Data, as expected by excelize
+	Width     int    // Decoded width in pixels
+	Height    int    // Decoded height in pixels
+
+	sum     [sha256.Size]byte // Hash of the original bytes, filled in by loadImages with Dedupe
+	warning string            // Warning about the image from loadImage, printed by its caller
+}
+
+// getImageFiles returns the sorted image files of the folder, or those listed
+// in the manifest when one is given, dropping the first opts.Skip images and
+// truncating the rest to opts.Limit images
+func getImageFiles(folderPath string, opts Options) ([]ImageInfo, error) {
+	// Accept either separator and trailing separators on every platform, so
+	// the image paths are built from a clean folder path
+	folderPath = filepath.Clean(filepath.FromSlash(folderPath))
+
+	var images []ImageInfo
+	var err error
+	// Listed images are checked for size here, walked ones during the walk
+	listed := true
+	switch {
+	case folderPath == StdinFolder:
+		images, err = readImageList(opts.input())
+	case opts.Manifest != "" && isZipArchive(folderPath):
+		return nil, fmt.Errorf("a manifest cannot be combined with a ZIP archive")
+	case opts.Manifest != "":
+		images, err = readManifest(folderPath, opts.Manifest)
+	case opts.PlacementFile != "" && isZipArchive(folderPath):
+		return nil, fmt.Errorf("a placement file cannot be combined with a ZIP archive")
+	case opts.PlacementFile != "":
+		images, err = readPlacements(folderPath, opts.PlacementFile)
+	case isZipArchive(folderPath):
+		images, err = walkZipFiles(folderPath, opts)
+		listed = false
+	default:
+		images, err = walkImageFiles(folderPath, opts)
+		listed = false
+	}
+	if err != nil {
+		return nil, err
+	}
+	if listed {
+		if images, err = skipListedBySize(images, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	images = images[min(opts.Skip, len(images)):]
+	if opts.Limit > 0 && len(images) > opts.Limit {
+		images = images[:opts.Limit]
+	}
+	return images, nil
+}
+
+// walkImageFiles walks through the folder and returns sorted image files.
+// Subfolders are only descended into when opts.Recursive is set.
+func walkImageFiles(folderPath string, opts Options) ([]ImageInfo, error) {
+	ignore, err := readIgnoreFile(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []ImageInfo
+	err = filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if !opts.Recursive && path != folderPath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relPath, err := filepath.Rel(folderPath, path)
+		if err != nil {
+			return err
+		}
+		if accepted, err := acceptImage(path, relPath, info.ModTime(), ignore, opts); err != nil || !accepted {
+			return err
+		}
+		if skipped, err := skipBySize(path, info.Size(), opts); err != nil || skipped {
+			return err
+		}
+		images = append(images, ImageInfo{
+			FilePath: filepath.Join(folderPath, relPath),
+			RelPath:  relPath,
+			Info:     info,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortImages(images, opts.SortBy, opts.Reverse)
+	return images, nil
+}
+
+// isZipArchive reports whether the image folder path names a ZIP archive
+func isZipArchive(folderPath string) bool {
+	return strings.EqualFold(filepath.Ext(folderPath), ".zip")
+}
+
+// walkZipFiles returns the sorted image entries of a ZIP archive, read into
+// memory. Entries in subfolders are only included when opts.Recursive is set.
+func walkZipFiles(zipPath string, opts Options) ([]ImageInfo, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer archive.Close()
+
+	var ignore ignoreRules
+	for _, entry := range archive.File {
+		if entry.Name == ignoreFile {
+			r, err := entry.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s from archive: %w", ignoreFile, err)
+			}
+			ignore, err = parseIgnoreRules(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var images []ImageInfo
+	for _, entry := range archive.File {
+		info := entry.FileInfo()
+		// Some Windows tools store entry names with backslashes
+		name := strings.ReplaceAll(entry.Name, `\`, "/")
+		// Skip folders and the resource forks macOS adds to archives
+		if info.IsDir() || strings.HasPrefix(name, "__MACOSX/") {
+			continue
+		}
+		if !opts.Recursive && strings.Contains(name, "/") {
+			continue
+		}
+		relPath := filepath.FromSlash(name)
+		path := filepath.Join(zipPath, relPath)
+		if accepted, err := acceptImage(path, relPath, info.ModTime(), ignore, opts); err != nil {
+			return nil, err
+		} else if !accepted {
+			continue
+		}
+		if skipped, err := skipBySize(path, info.Size(), opts); err != nil {
+			return nil, err
+		} else if skipped {
+			continue
+		}
+		data, err := readZipEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from archive: %w", name, err)
+		}
+		images = append(images, ImageInfo{
+			FilePath: path,
+			RelPath:  relPath,
+			Info:     info,
+			Source:   data,
+		})
+	}
+
+	sortImages(images, opts.SortBy, opts.Reverse)
+	return images, nil
+}
+
+// readZipEntry returns the uncompressed bytes of an archive entry
+func readZipEntry(entry *zip.File) ([]byte, error) {
+	r, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// acceptImage reports whether the file passes the extension, ignore file,
+// glob and modification time filters, logging the reason it is skipped
+// otherwise
+func acceptImage(path, relPath string, modTime time.Time, ignore ignoreRules, opts Options) (bool, error) {
+	if !slices.Contains(opts.Extensions, strings.ToLower(filepath.Ext(path))) {
+		opts.logf("Skipping %s: not an accepted image extension", path)
+		return false, nil
+	}
+	if ignore.ignored(relPath) {
+		opts.logf("Skipping %s: excluded by %s", path, ignoreFile)
+		return false, nil
+	}
+	if opts.Glob != "" {
+		matched, err := matchGlob(opts.Glob, relPath)
+		if err != nil {
+			return false, err
+		}
+		if !matched {
+			opts.logf("Skipping %s: does not match %s", path, opts.Glob)
+			return false, nil
+		}
+	}
+	if !opts.Since.IsZero() && modTime.Before(opts.Since) {
+		opts.logf("Skipping %s: modified before %s", path, opts.Since.Format(time.RFC3339))
+		return false, nil
+	}
+	if !opts.Until.IsZero() && modTime.After(opts.Until) {
+		opts.logf("Skipping %s: modified after %s", path, opts.Until.Format(time.RFC3339))
+		return false, nil
+	}
+	return true, nil
+}
+
+// skipBySize reports whether the image file is skipped for its size: empty,
+// as an interrupted capture can leave behind, or larger than
+// opts.MaxFileSize megabytes. Skipped files are warned about, or with
+// opts.FailFast reported as an error instead.
+func skipBySize(path string, size int64, opts Options) (bool, error) {
+	reason := sizeProblem(size, opts)
+	if reason == "" {
+		return false, nil
+	}
+	if opts.FailFast {
+		return true, fmt.Errorf("image %s: %s", path, reason)
+	}
+	fmt.Fprintf(opts.warnings(), "Warning: skipping %s, %s\n", path, reason)
+	return true, nil
+}
+
+// sizeProblem returns why an image of size bytes is skipped, or "" when it
+// is not
+func sizeProblem(size int64, opts Options) string {
+	switch {
+	case size == 0:
+		return "the file is empty"
+	case opts.MaxFileSize > 0 && float64(size) > opts.MaxFileSize*(1<<20):
+		return fmt.Sprintf("the file is larger than %g MB", opts.MaxFileSize)
+	}
+	return ""
+}
+
+// skipListedBySize drops the local images of a list, manifest or placement
+// file that skipBySize skips. URLs are checked when they are fetched.
+func skipListedBySize(images []ImageInfo, opts Options) ([]ImageInfo, error) {
+	kept := images[:0]
+	for _, img := range images {
+		if img.Info != nil {
+			if skipped, err := skipBySize(img.FilePath, img.Info.Size(), opts); err != nil {
+				return nil, err
+			} else if skipped {
+				continue
+			}
+		}
+		kept = append(kept, img)
+	}
+	return kept, nil
+}
+
+// readImageList reads newline-separated image paths or http(s) URLs and
+// returns them in the given order. Blank lines are ignored.
+func readImageList(r io.Reader) ([]ImageInfo, error) {
+	var images []ImageInfo
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		filePath := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(filePath) == "" {
+			continue
+		}
+		if isURL(filePath) {
+			images = append(images, ImageInfo{FilePath: filePath, RelPath: filePath})
+			continue
+		}
+		filePath = filepath.Clean(filepath.FromSlash(filePath))
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("image list line %d: image %s not found: %w", line, filePath, err)
+		}
+		images = append(images, ImageInfo{FilePath: filePath, RelPath: filePath, Info: info})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read image list: %w", err)
+	}
+	return images, nil
+}
+
+// readManifest reads a CSV file with order,filename,caption columns and
+// returns the listed images sorted by their order. File names are relative to
+// the image folder and a leading header row is skipped.
+func readManifest(folderPath, manifestPath string) ([]ImageInfo, error) {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", manifestPath, err)
+	}
+
+	type entry struct {
+		order int
+		image ImageInfo
+	}
+	var entries []entry
+	for index, record := range records {
+		if index == 0 && len(record) > 0 && strings.EqualFold(record[0], "order") {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("manifest %s line %d: expected order,filename[,caption]", manifestPath, index+1)
+		}
+		order, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s line %d: invalid order %q", manifestPath, index+1, record[0])
+		}
+		img, err := manifestImage(folderPath, record[1])
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s line %d: image %s not found: %w", manifestPath, index+1, record[1], err)
+		}
+		if len(record) > 2 {
+			img.Caption = record[2]
+		}
+		entries = append(entries, entry{order: order, image: img})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].order < entries[j].order
+	})
+	images := make([]ImageInfo, 0, len(entries))
+	for _, e := range entries {
+		images = append(images, e.image)
+	}
+	return images, nil
+}
+
+// readPlacements reads a CSV file of cell,filename pairs and returns the
+// listed images in file order, each with the cell it is placed at. Filenames
+// are relative to the image folder unless absolute. Two images at the same
+// cell are reported as an error, since one would hide the other.
+func readPlacements(folderPath, placementPath string) ([]ImageInfo, error) {
+	file, err := os.Open(placementPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open placement file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read placement file %s: %w", placementPath, err)
+	}
+
+	var images []ImageInfo
+	used := map[string]string{}
+	for index, record := range records {
+		if index == 0 && len(record) > 0 && strings.EqualFold(record[0], "cell") {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("placement file %s line %d: expected cell,filename", placementPath, index+1)
+		}
+		col, row, err := excelize.CellNameToCoordinates(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("placement file %s line %d: invalid cell %q", placementPath, index+1, record[0])
+		}
+		cell, _ := excelize.CoordinatesToCellName(col, row)
+		if other, ok := used[cell]; ok {
+			return nil, fmt.Errorf("placement file %s line %d: cell %s is already used by %s", placementPath, index+1, cell, other)
+		}
+		used[cell] = record[1]
+
+		img, err := manifestImage(folderPath, record[1])
+		if err != nil {
+			return nil, fmt.Errorf("placement file %s line %d: image %s not found: %w", placementPath, index+1, record[1], err)
+		}
+		img.Cell = cell
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// manifestImage returns the image named in a manifest or placement file,
+// relative to the image folder unless absolute. URLs are kept as they are and
+// only fetched when the image is read.
+func manifestImage(folderPath, name string) (ImageInfo, error) {
+	if isURL(name) {
+		return ImageInfo{FilePath: name, RelPath: name}, nil
+	}
+	relPath := filepath.FromSlash(name)
+	filePath := relPath
+	if !filepath.IsAbs(relPath) {
+		filePath = filepath.Join(folderPath, relPath)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	return ImageInfo{FilePath: filePath, RelPath: relPath, Info: info}, nil
+}
+
+// ParseExtensions splits a comma-separated extension list into normalized
+// lower-case extensions with a leading dot
+func ParseExtensions(list string) []string {
+	var extensions []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions
+}
+
+// matchGlob reports whether relPath matches pattern. Patterns without a slash
+// are matched against the file name alone, others against the whole relative
+// path, where a "**" segment matches any number of folders.
+func matchGlob(pattern, relPath string) (bool, error) {
+	relPath = filepath.ToSlash(relPath)
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "/") {
+		return path.Match(pattern, path.Base(relPath))
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+}
+
+// matchSegments matches slash-separated path segments against pattern
+// segments, expanding "**" to zero or more segments
+func matchSegments(pattern, segments []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(segments) == 0, nil
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			matched, err := matchSegments(pattern[1:], segments[i:])
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+	if len(segments) == 0 {
+		return false, nil
+	}
+	matched, err := path.Match(pattern[0], segments[0])
+	if err != nil || !matched {
+		return false, err
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// sortImages orders images by the given key: name, natural, mtime or size.
+// Ties on mtime and size fall back to natural filename order.
+func sortImages(images []ImageInfo, sortBy string, reverse bool) {
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		switch sortBy {
+		case "mtime":
+			if !a.Info.ModTime().Equal(b.Info.ModTime()) {
+				return a.Info.ModTime().Before(b.Info.ModTime())
+			}
+		case "size":
+			if a.Info.Size() != b.Info.Size() {
+				return a.Info.Size() < b.Info.Size()
+			}
+		}
+		return nameLess(a.RelPath, b.RelPath, sortBy != "name")
+	})
+	if reverse {
+		slices.Reverse(images)
+	}
+}
+
+// nameLess orders filenames without numbers before those with numbers, then
+// compares them naturally or lexically
+func nameLess(a, b string, natural bool) bool {
+	hasNumA := numberPattern.MatchString(a)
+	hasNumB := numberPattern.MatchString(b)
+
+	if hasNumA && !hasNumB {
+		return false
+	} else if !hasNumA && hasNumB {
+		return true
+	}
+	if natural {
+		return naturalLess(a, b)
+	}
+	return a < b
+}
+
+// naturalLess reports whether a sorts before b, comparing runs of digits by
+// their numeric value so that "img2" sorts before "img10"
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		numA, restA := splitDigits(a)
+		numB, restB := splitDigits(b)
+		if numA != "" && numB != "" {
+			trimmedA := strings.TrimLeft(numA, "0")
+			trimmedB := strings.TrimLeft(numB, "0")
+			if len(trimmedA) != len(trimmedB) {
+				return len(trimmedA) < len(trimmedB)
+			}
+			if trimmedA != trimmedB {
+				return trimmedA < trimmedB
+			}
+			if numA != numB {
+				// Equal values, fewer leading zeros first
+				return len(numA) < len(numB)
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// splitDigits splits the leading run of digits off s
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i], s[i:]
+}
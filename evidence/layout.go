@@ -0,0 +1,797 @@
+package evidence
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// pasteImages places images in the sheet using the configured layout
+func pasteImages(f *excelize.File, sheetName string, images []ImageInfo, opts Options) error {
+	if opts.PlacementFile != "" {
+		return pasteImagesAtCells(f, sheetName, images, opts)
+	}
+	if opts.Table != "" {
+		return pasteImagesIntoTable(f, sheetName, images, opts)
+	}
+	switch opts.Layout {
+	case "vertical":
+		return pasteImagesVertically(f, sheetName, images, opts)
+	case "grid":
+		return pasteImagesGrid(f, sheetName, images, opts)
+	default:
+		return pasteImagesHorizontally(f, sheetName, images, opts)
+	}
+}
+
+// resolveStartCell returns the start cell, looking it up among the defined
+// names when it is not a cell reference. Names scoped to the sheet take
+// precedence over workbook names, and the name must refer to the sheet or to
+// the template sheet it was copied from, if any.
+func resolveStartCell(f *excelize.File, sheetName, templateSheet, start string) (string, error) {
+	if _, _, err := excelize.CellNameToCoordinates(start); err == nil {
+		return start, nil
+	}
+	var refersTo string
+	for _, name := range f.GetDefinedName() {
+		if !strings.EqualFold(name.Name, start) {
+			continue
+		}
+		if name.Scope == sheetName {
+			refersTo = name.RefersTo
+			break
+		}
+		if name.Scope == "Workbook" && refersTo == "" {
+			refersTo = name.RefersTo
+		}
+	}
+	if refersTo == "" {
+		return "", fmt.Errorf("not a cell or a defined name of sheet %s", sheetName)
+	}
+
+	// RefersTo looks like 'Sheet 1'!$B$4 or Sheet1!$B$4:$D$8
+	sheet, ref, ok := strings.Cut(strings.TrimPrefix(refersTo, "="), "!")
+	if !ok {
+		return "", fmt.Errorf("defined name does not refer to a cell: %s", refersTo)
+	}
+	sheet = strings.ReplaceAll(strings.Trim(sheet, "'"), "''", "'")
+	if sheet != sheetName && (templateSheet == "" || sheet != templateSheet) {
+		return "", fmt.Errorf("defined name refers to sheet %s, not %s", sheet, sheetName)
+	}
+	cell, _, _ := strings.Cut(ref, ":")
+	cell = strings.ReplaceAll(cell, "$", "")
+	if _, _, err := excelize.CellNameToCoordinates(cell); err != nil {
+		return "", fmt.Errorf("defined name does not refer to a cell: %s", refersTo)
+	}
+	return cell, nil
+}
+
+// appendStart returns the cell the layout continues at after the pictures
+// already in the sheet at or past the start cell, one column or row step past
+// the last of them, or the start cell when there are none. Grid layouts
+// continue on the next page. A page break is inserted before the continued
+// layout where the page break mode expects one after the existing pictures.
+func appendStart(f *excelize.File, sheetName string, opts Options) (string, error) {
+	startCol, startRow, err := excelize.CellNameToCoordinates(opts.StartCell)
+	if err != nil {
+		return "", fmt.Errorf("invalid starting cell: %w", err)
+	}
+	cells, err := f.GetPictureCells(sheetName)
+	if err != nil {
+		return "", err
+	}
+	existing, lastCol, lastRow := 0, 0, 0
+	for _, cell := range cells {
+		col, row, err := excelize.CellNameToCoordinates(cell)
+		if err != nil {
+			return "", err
+		}
+		// Pictures above or left of the layout, such as a logo, are not part of it
+		if col < startCol || row < startRow {
+			continue
+		}
+		existing++
+		lastCol, lastRow = max(lastCol, col), max(lastRow, row)
+	}
+	if existing == 0 {
+		return opts.StartCell, nil
+	}
+
+	opts.logf("Appending after %d pictures in sheet %s", existing, sheetName)
+	col, row := startCol, startRow
+	breakCol, breakRow := 1, 1
+	breakDue := pageBreakDue(existing-1, opts)
+	switch opts.Layout {
+	case "vertical":
+		row = lastRow + opts.RowStep
+		breakRow = row
+	case "grid":
+		page := (lastRow-startRow)/opts.RowStep + 1
+		row = startRow + page*opts.RowStep
+		breakRow = row
+		breakDue = pageBreakDue(page-1, opts)
+	default:
+		col = lastCol + columnStep(f, sheetName, opts)
+		breakCol, breakRow = col-(startCol-1), opts.BreakRow
+	}
+	if breakDue {
+		if err := insertPageBreak(f, sheetName, breakCol, breakRow); err != nil {
+			return "", err
+		}
+	}
+	return excelize.CoordinatesToCellName(col, row)
+}
+
+// pasteImagesHorizontally places images horizontally in the Excel sheet
+func pasteImagesHorizontally(f *excelize.File, sheetName string, images []ImageInfo, opts Options) error {
+	startCol, row, err := excelize.CellNameToCoordinates(opts.StartCell)
+	if err != nil {
+		return fmt.Errorf("invalid starting cell: %w", err)
+	}
+	currentCol := startCol
+	colStep := columnStep(f, sheetName, opts)
+	groups := groupStarts(images, opts)
+	if opts.Spacing > 0 {
+		opts.placed = &placedWidth{}
+	}
+
+	for index, img := range images {
+		if index > 0 {
+			// Leave a gap before each new group of images
+			if groups[index] {
+				currentCol += opts.GroupGap
+			}
+
+			// Insert a page break between the previous image and this one,
+			// keeping the same left margin on every page as before the first image
+			if pageBreakDue(index-1, opts) {
+				if err := insertPageBreak(f, sheetName, currentCol-(startCol-1), opts.BreakRow); err != nil {
+					return err
+				}
+			}
+		}
+		cellName, _ := excelize.CoordinatesToCellName(currentCol, row)
+
+		// Add the image at the current position
+		if groups[index] {
+			if err := addGroupLabel(f, sheetName, currentCol, row, img, opts); err != nil {
+				return err
+			}
+		}
+		imgOpts := opts
+		if opts.ColumnsFromTemplate {
+			// Fit the image undistorted to the width of its own column
+			colPixels, err := columnPixels(f, sheetName, currentCol)
+			if err != nil {
+				return err
+			}
+			imgOpts.Width = max(colPixels-float64(opts.OffsetX), 1)
+			imgOpts.PreserveAspect = true
+		}
+		opts.placed.set(0)
+		if err := pasteImage(f, sheetName, img, cellName, imgOpts); err != nil {
+			return err
+		}
+
+		// Move to the next column with spacing
+		step, err := imageStep(f, sheetName, cellName, colStep, opts)
+		if err != nil {
+			return err
+		}
+		currentCol += step
+	}
+	return nil
+}
+
+// imageStep returns the number of columns to advance past the image just
+// placed at the cell: with opts.Spacing, the columns covering its width times
+// the spacing, otherwise, or when the image was skipped, colStep
+func imageStep(f *excelize.File, sheetName, cellName string, colStep int, opts Options) (int, error) {
+	if opts.placed == nil || opts.placed.pixels <= 0 {
+		return colStep, nil
+	}
+	col, _, err := excelize.CellNameToCoordinates(cellName)
+	if err != nil {
+		return 0, err
+	}
+	lastCol, _, err := imageExtent(f, sheetName, cellName, opts.placed.pixels*opts.Spacing, 0)
+	if err != nil {
+		return 0, err
+	}
+	return max(lastCol-col+1, 1), nil
+}
+
+// pasteImagesAtCells adds each image at the cell given for it in the placement
+// file, ignoring the layout and page break settings
+func pasteImagesAtCells(f *excelize.File, sheetName string, images []ImageInfo, opts Options) error {
+	for _, img := range images {
+		if err := pasteImage(f, sheetName, img, img.Cell, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pasteImagesIntoTable adds one image per data row of the named table, in the
+// column of the start cell, fitting each image undistorted to its row height
+func pasteImagesIntoTable(f *excelize.File, sheetName string, images []ImageInfo, opts Options) error {
+	col, _, err := excelize.CellNameToCoordinates(opts.StartCell)
+	if err != nil {
+		return fmt.Errorf("invalid starting cell: %w", err)
+	}
+	firstRow, lastRow, err := tableRows(f, sheetName, opts.Table)
+	if err != nil {
+		return err
+	}
+	if rows := lastRow - firstRow + 1; len(images) > rows {
+		return fmt.Errorf("table %s has %d data rows for %d images", opts.Table, rows, len(images))
+	}
+
+	for index, img := range images {
+		row := firstRow + index
+		rowHeight, err := f.GetRowHeight(sheetName, row)
+		if err != nil {
+			return err
+		}
+		imgOpts := opts
+		imgOpts.Height = max(rowHeight/0.75-float64(opts.OffsetY), 1)
+		imgOpts.PreserveAspect = true
+		cellName, _ := excelize.CoordinatesToCellName(col, row)
+		if err := pasteImage(f, sheetName, img, cellName, imgOpts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableRows returns the first and last data rows of the named table, which
+// follow its header row unless the table hides it
+func tableRows(f *excelize.File, sheetName, tableName string) (int, int, error) {
+	tables, err := f.GetTables(sheetName)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, table := range tables {
+		if !strings.EqualFold(table.Name, tableName) {
+			continue
+		}
+		first, last, ok := strings.Cut(table.Range, ":")
+		_, firstRow, err := excelize.CellNameToCoordinates(first)
+		if err != nil || !ok {
+			return 0, 0, fmt.Errorf("invalid range %q of table %s", table.Range, table.Name)
+		}
+		_, lastRow, err := excelize.CellNameToCoordinates(last)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid range %q of table %s", table.Range, table.Name)
+		}
+		if table.ShowHeaderRow == nil || *table.ShowHeaderRow {
+			firstRow++
+		}
+		return firstRow, lastRow, nil
+	}
+	return 0, 0, fmt.Errorf("table %s not found in sheet %s", tableName, sheetName)
+}
+
+// pasteImagesVertically stacks images in a single column, advancing
+// opts.RowStep rows between images and placing each one on its own printed page
+func pasteImagesVertically(f *excelize.File, sheetName string, images []ImageInfo, opts Options) error {
+	col, currentRow, err := excelize.CellNameToCoordinates(opts.StartCell)
+	if err != nil {
+		return fmt.Errorf("invalid starting cell: %w", err)
+	}
+	groups := groupStarts(images, opts)
+
+	for index, img := range images {
+		if index > 0 {
+			// Leave a gap before each new group of images
+			if groups[index] {
+				currentRow += opts.GroupGap
+			}
+
+			// Insert a row page break between the previous image and this one
+			if pageBreakDue(index-1, opts) {
+				if err := insertPageBreak(f, sheetName, 1, currentRow); err != nil {
+					return err
+				}
+			}
+		}
+		cellName, _ := excelize.CoordinatesToCellName(col, currentRow)
+
+		// Add the image at the current position
+		if groups[index] {
+			if err := addGroupLabel(f, sheetName, col, currentRow, img, opts); err != nil {
+				return err
+			}
+		}
+		if err := pasteImage(f, sheetName, img, cellName, opts); err != nil {
+			return err
+		}
+
+		// Move to the next row with spacing
+		currentRow += opts.RowStep
+	}
+	return nil
+}
+
+// pasteImagesGrid lays images out row-major in a grid of opts.Cols x
+// opts.Rows images per printed page. Each page spans the same area as a single
+// horizontal image and pages are stacked down the sheet, opts.RowStep rows
+// apart. Each new group of images starts on a new page.
+func pasteImagesGrid(f *excelize.File, sheetName string, images []ImageInfo, opts Options) error {
+	startCol, startRow, err := excelize.CellNameToCoordinates(opts.StartCell)
+	if err != nil {
+		return fmt.Errorf("invalid starting cell: %w", err)
+	}
+
+	cols, rows, pageRows := opts.Cols, opts.Rows, opts.RowStep
+	perPage := cols * rows
+	colStep := max(columnStep(f, sheetName, opts)/cols, 1)
+	rowStep := max(pageRows/rows, 1)
+	cellOpts := opts
+	cellOpts.Width = opts.Width / float64(cols)
+	cellOpts.Height = opts.Height / float64(rows)
+	groups := groupStarts(images, opts)
+
+	position, rowOffset := 0, 0
+	for index, img := range images {
+		if index > 0 && groups[index] && position%perPage != 0 {
+			position += perPage - position%perPage
+		}
+		page, slot := position/perPage, position%perPage
+
+		// Center a row left partly empty at the end of the images or of a group
+		if slot%cols == 0 {
+			rowOffset = 0
+			if opts.CenterLastRow {
+				rowOffset = (cols - rowLength(images[index:], groups[index:], cols)) * colStep / 2
+			}
+		}
+		col := startCol + (slot%cols)*colStep + rowOffset
+		row := startRow + page*pageRows + (slot/cols)*rowStep
+		cellName, _ := excelize.CoordinatesToCellName(col, row)
+
+		// Insert a row page break before each page except the first one
+		if slot == 0 && page > 0 && pageBreakDue(page-1, opts) {
+			if err := insertPageBreak(f, sheetName, 1, startRow+page*pageRows); err != nil {
+				return err
+			}
+		}
+
+		// Add the image at its grid position
+		if groups[index] {
+			if err := addGroupLabel(f, sheetName, col, row, img, opts); err != nil {
+				return err
+			}
+		}
+		if err := pasteImage(f, sheetName, img, cellName, cellOpts); err != nil {
+			return err
+		}
+		position++
+	}
+	return nil
+}
+
+// groupStarts reports for each image whether it starts a new group of
+// images sharing a file name prefix. Without opts.GroupByPrefix the images
+// form a single group.
+func groupStarts(images []ImageInfo, opts Options) []bool {
+	starts := make([]bool, len(images))
+	for i, img := range images {
+		if i == 0 {
+			starts[i] = opts.GroupByPrefix
+			continue
+		}
+		starts[i] = opts.GroupByPrefix && groupPrefix(img, opts.GroupDelimiters) != groupPrefix(images[i-1], opts.GroupDelimiters)
+	}
+	return starts
+}
+
+// groupPrefix returns the image's file name up to the first digit or
+// delimiter character, e.g. login for login_1.png
+func groupPrefix(img ImageInfo, delimiters string) string {
+	name := filepath.Base(img.FilePath)
+	end := strings.IndexFunc(name, func(r rune) bool {
+		return unicode.IsDigit(r) || strings.ContainsRune(delimiters, r)
+	})
+	if end < 0 {
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name[:end]
+}
+
+// addGroupLabel writes the image's group prefix into the cell above the
+// first image of the group, when there is a row above it
+func addGroupLabel(f *excelize.File, sheetName string, col, row int, img ImageInfo, opts Options) error {
+	if row == 1 || opts.DryRun {
+		return nil
+	}
+	cell, err := excelize.CoordinatesToCellName(col, row-1)
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellValue(sheetName, cell, groupPrefix(img, opts.GroupDelimiters)); err != nil {
+		return fmt.Errorf("failed to write group label at %s: %w", cell, err)
+	}
+	return nil
+}
+
+// rowLength returns how many of the images fill the grid row they start,
+// which ends after cols images or before the next group
+func rowLength(images []ImageInfo, groups []bool, cols int) int {
+	n := 1
+	for n < min(cols, len(images)) && !groups[n] {
+		n++
+	}
+	return n
+}
+
+// columnStep returns the configured column step, or one computed from the
+// desired image width and the sheet's default column width plus one spare
+// column when it is 0. With this tool's defaults and the sample template's
+// 3.71 character columns (31 pixels) this gives 37.
+func columnStep(f *excelize.File, sheetName string, opts Options) int {
+	if opts.ColStep > 0 {
+		return opts.ColStep
+	}
+	if opts.ColumnsFromTemplate {
+		return 1
+	}
+	if opts.FitCells {
+		// The image fills its own column, followed by a spare one
+		return 2
+	}
+	width := defaultColWidth
+	if props, err := f.GetSheetProps(sheetName); err == nil && props.DefaultColWidth != nil && *props.DefaultColWidth > 0 {
+		width = *props.DefaultColWidth
+	}
+	return int(math.Ceil(opts.Width/charsToPixels(width))) + 1
+}
+
+// pageBreakDue reports whether a page break follows the given image, or page
+// in grid layout, according to the page break mode
+func pageBreakDue(index int, opts Options) bool {
+	switch opts.PageBreak {
+	case "none":
+		return false
+	case "every-n":
+		return (index+1)%opts.BreakEvery == 0
+	}
+	return true
+}
+
+// insertPageBreak inserts a page break before the given column and row. A
+// column or row of 1 adds no break in that direction.
+func insertPageBreak(f *excelize.File, sheetName string, col, row int) error {
+	pageBreakCell, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return err
+	}
+	if err := f.InsertPageBreak(sheetName, pageBreakCell); err != nil {
+		return fmt.Errorf("failed to insert page break at %s: %w", pageBreakCell, err)
+	}
+	return nil
+}
+
+// pasteImage places an image at the given cell. When failures are being
+// collected an image that cannot be placed is recorded and skipped, leaving
+// its cell empty. Either way the image counts towards the progress.
+func pasteImage(f *excelize.File, sheetName string, img ImageInfo, cellName string, opts Options) error {
+	defer opts.progress.step()
+	err := placeImage(f, sheetName, img, cellName, opts)
+	if err != nil && opts.failures != nil {
+		opts.failures.add(img, err, opts)
+		return nil
+	}
+	return err
+}
+
+// placeImage scales an image to opts.Width x opts.Height pixels and adds it
+// at the given cell
+func placeImage(f *excelize.File, sheetName string, img ImageInfo, cellName string, opts Options) error {
+	// In stream mode images are read one at a time as they are placed, and
+	// this copy of the bytes is dropped once excelize has embedded them
+	if img.Data == nil {
+		if err := loadImage(&img, opts); err != nil {
+			return err
+		}
+		img.printWarning(opts)
+	}
+
+	// Original dimensions of the image, decoded by loadImage
+	originalWidth, originalHeight := img.Width, img.Height
+
+	// A caption above the image takes the image's cell, moving the image down
+	captionCell, captionOffset := cellName, opts.CaptionOffset
+	captioned := opts.Caption || opts.TimestampCaption
+	if captioned && opts.CaptionPosition == "above" {
+		if captionOffset == 0 {
+			captionOffset = 1
+		}
+		col, row, err := excelize.CellNameToCoordinates(cellName)
+		if err != nil {
+			return err
+		}
+		if cellName, err = excelize.CoordinatesToCellName(col, row+captionOffset); err != nil {
+			return err
+		}
+		captionOffset = 0
+	}
+
+	// Calculate scaling factors
+	scaleX := opts.Width / float64(originalWidth)
+	scaleY := opts.Height / float64(originalHeight)
+	if opts.PreserveAspect {
+		// Use the smaller factor so the image fits the box undistorted
+		scaleX = min(scaleX, scaleY)
+		scaleY = scaleX
+	}
+	if opts.WidthCM > 0 {
+		scaleX = exactScale(scaleX, originalWidth)
+		scaleY = exactScale(scaleY, originalHeight)
+	}
+	if opts.AutoFit {
+		// excelize shrinks the image to fit its cell instead
+		scaleX, scaleY = 1, 1
+	}
+	if scale, ok, err := sidecarScale(img); err != nil {
+		return err
+	} else if ok {
+		scaleX, scaleY = scale, scale
+	}
+
+	opts.logf("Placing %s at %s with scale %.4f x %.4f", img.FilePath, cellName, scaleX, scaleY)
+
+	placement := Placement{Path: img.FilePath, Sheet: sheetName, Cell: cellName, ScaleX: scaleX, ScaleY: scaleY}
+
+	// Size of the image in the sheet, including its offset in the cell
+	width := float64(originalWidth)*scaleX + float64(opts.OffsetX)
+	height := float64(originalHeight)*scaleY + float64(opts.OffsetY)
+	if opts.AutoFit {
+		width, height = 1, 1
+	}
+	opts.placed.set(width)
+
+	if opts.DryRun {
+		fmt.Fprintf(opts.output(), "%s\t%s\tscale %.4f x %.4f\n", cellName, img.FilePath, scaleX, scaleY)
+		opts.place(placement)
+		return nil
+	}
+
+	// Size the cell before adding the image, as its anchor is computed from
+	// the current column widths and row heights
+	if opts.FitCells {
+		if err := fitCell(f, sheetName, cellName, width, height, opts); err != nil {
+			return err
+		}
+	}
+
+	// Add the image at the current position
+	err := addImage(f, sheetName, img, cellName, scaleX, scaleY, opts)
+	if err != nil {
+		return fmt.Errorf("failed to insert image %s: %w", img.FilePath, err)
+	}
+
+	opts.contents.add(img, sheetName, cellName)
+	if err := opts.area.includeImage(f, sheetName, cellName, width, height); err != nil {
+		return err
+	}
+
+	if opts.Border {
+		if err := addBorder(f, sheetName, cellName, width, height, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.Link {
+		if err := addLink(f, sheetName, cellName, img, opts.LinkBase); err != nil {
+			return err
+		}
+	}
+
+	if opts.CellComment {
+		if err := addComment(f, sheetName, cellName, img); err != nil {
+			return err
+		}
+	}
+
+	if captioned && opts.CaptionPosition == "above" {
+		if err := addCaption(f, sheetName, captionCell, 0, captionLine(img, opts)); err != nil {
+			return err
+		}
+	} else if captioned {
+		offset := captionOffset
+		if offset == 0 && (opts.FitCells || opts.AutoFit) {
+			offset = 1
+		} else if offset == 0 {
+			offset = int(math.Ceil(float64(originalHeight) * scaleY / defaultRowHeight))
+		}
+		if err := addCaption(f, sheetName, cellName, offset, captionLine(img, opts)); err != nil {
+			return err
+		}
+		col, row, _ := excelize.CellNameToCoordinates(cellName)
+		opts.area.include(col, row+offset)
+	}
+	opts.place(placement)
+	return nil
+}
+
+// sidecarScale reads the scale of the image from a sidecar file named after
+// it with a .scale suffix, such as 1.png.scale containing 0.5, reporting
+// whether there is one
+func sidecarScale(img ImageInfo) (float64, bool, error) {
+	if img.Source != nil {
+		// Images from an archive have no file to sit next to
+		return 0, false, nil
+	}
+	path := img.FilePath + ".scale"
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("failed to read scale file: %w", err)
+	}
+	scale, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil || scale <= 0 {
+		return 0, false, fmt.Errorf("invalid scale %q in %s, expected a positive number", strings.TrimSpace(string(data)), path)
+	}
+	return scale, true, nil
+}
+
+// exactScale adjusts a scale factor so the scaled size comes out as the
+// nearest whole pixel, since excelize truncates the size of pictures to whole
+// pixels of 9525 EMU each
+func exactScale(scale float64, size int) float64 {
+	return (math.Round(scale*float64(size)) + 0.5) / float64(size)
+}
+
+// fitCell resizes the cell's column and row to the given size in pixels,
+// capped at the largest width and height Excel allows
+func fitCell(f *excelize.File, sheetName, cellName string, width, height float64, opts Options) error {
+	col, row, err := excelize.CellNameToCoordinates(cellName)
+	if err != nil {
+		return err
+	}
+	colName, err := excelize.ColumnNumberToName(col)
+	if err != nil {
+		return err
+	}
+	// Row heights are measured in points of 4/3 pixels
+	colWidth := min(pixelsToChars(width), excelize.MaxColumnWidth)
+	rowHeight := min(height*0.75, excelize.MaxRowHeight)
+	if rowHeight < height*0.75 {
+		opts.logf("Row %d capped at %d points, the image at %s overflows it", row, excelize.MaxRowHeight, cellName)
+	}
+	if err := f.SetColWidth(sheetName, colName, colName, colWidth); err != nil {
+		return fmt.Errorf("failed to set the width of column %s: %w", colName, err)
+	}
+	if err := f.SetRowHeight(sheetName, row, rowHeight); err != nil {
+		return fmt.Errorf("failed to set the height of row %d: %w", row, err)
+	}
+	return nil
+}
+
+// addCaption writes text into the cell offset rows below cellName
+func addCaption(f *excelize.File, sheetName, cellName string, offset int, text string) error {
+	col, row, err := excelize.CellNameToCoordinates(cellName)
+	if err != nil {
+		return err
+	}
+	captionCell, err := excelize.CoordinatesToCellName(col, row+offset)
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellValue(sheetName, captionCell, text); err != nil {
+		return fmt.Errorf("failed to write caption at %s: %w", captionCell, err)
+	}
+	return nil
+}
+
+// addLink sets a hyperlink on the image's cell pointing to its source file,
+// or to the image's relative path under baseURL when one is given
+func addLink(f *excelize.File, sheetName, cellName string, img ImageInfo, baseURL string) error {
+	target, err := imageLink(img, baseURL)
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellHyperLink(sheetName, cellName, target, "External"); err != nil {
+		return fmt.Errorf("failed to set hyperlink at %s: %w", cellName, err)
+	}
+	return nil
+}
+
+// addComment attaches a comment with the image's file name, pixel dimensions
+// and caption to its cell, shown by Excel only when the cell is hovered
+func addComment(f *excelize.File, sheetName, cellName string, img ImageInfo) error {
+	text := fmt.Sprintf("%s\n%dx%d px", filepath.ToSlash(img.RelPath), img.Width, img.Height)
+	if img.Caption != "" {
+		text += "\n" + img.Caption
+	}
+	if err := f.AddComment(sheetName, excelize.Comment{Cell: cellName, Text: text}); err != nil {
+		return fmt.Errorf("failed to add comment at %s: %w", cellName, err)
+	}
+	return nil
+}
+
+// imageLink returns the hyperlink target of the image: its URL when it was
+// fetched, its relative path under baseURL when one is given, otherwise the
+// absolute path of its file, or of its archive for a ZIP entry
+func imageLink(img ImageInfo, baseURL string) (string, error) {
+	if isURL(img.FilePath) {
+		return img.FilePath, nil
+	}
+	if baseURL != "" {
+		segments := strings.Split(filepath.ToSlash(img.RelPath), "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return strings.TrimSuffix(baseURL, "/") + "/" + strings.Join(segments, "/"), nil
+	}
+	if img.Source != nil {
+		// The entry path inside the archive does not exist on disk
+		return filepath.Abs(strings.TrimSuffix(img.FilePath, string(filepath.Separator)+img.RelPath))
+	}
+	return filepath.Abs(img.FilePath)
+}
+
+// captionLine returns the text of the image's caption cell: its caption,
+// its modification time, or both separated by a dash
+func captionLine(img ImageInfo, opts Options) string {
+	if !opts.TimestampCaption || img.Info == nil {
+		return captionText(img)
+	}
+	timestamp := img.Info.ModTime().Format(opts.TimeFormat)
+	if !opts.Caption {
+		return timestamp
+	}
+	return captionText(img) + " - " + timestamp
+}
+
+// captionText returns the image's caption, or its path relative to the
+// folder without the extension when it has none, so images with the same
+// name in different subfolders can be told apart. URLs use the last segment of
+// their path.
+func captionText(img ImageInfo) string {
+	if img.Caption != "" {
+		return img.Caption
+	}
+	name := filepath.Base(img.FilePath)
+	if isURL(img.FilePath) {
+		if u, err := url.Parse(img.FilePath); err == nil && strings.Trim(u.Path, "/") != "" {
+			name = path.Base(u.Path)
+		}
+	} else if img.RelPath != "" && filepath.IsLocal(img.RelPath) {
+		name = filepath.ToSlash(img.RelPath)
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// addImage adds an image at a specific cell in the Excel sheet, offset from
+// the cell's top-left corner and anchored as set by opts.Positioning
+func addImage(f *excelize.File, sheetName string, img ImageInfo, cell string, scaleX, scaleY float64, opts Options) error {
+	err := f.AddPictureFromBytes(sheetName, cell, &excelize.Picture{
+		Extension: img.Extension,
+		File:      img.Data,
+		Format: &excelize.GraphicOptions{
+			ScaleX:      scaleX,
+			ScaleY:      scaleY,
+			AutoFit:     opts.AutoFit,
+			OffsetX:     opts.OffsetX,
+			OffsetY:     opts.OffsetY,
+			Positioning: opts.Positioning,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert image: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package evidence
+
+import "fmt"
+
+// List prints the image files that would be inserted, one path per line in
+// insertion order, after the extension, glob, .evidenceignore, skip and limit
+// filters and the sort. The template is not opened and no image is decoded, so
+// duplicates dropped by opts.Dedupe are still listed.
+func List(opts Options) error {
+	targets := opts.targets()
+	if opts.SplitByFolder || opts.SplitFiles {
+		var err error
+		if targets, err = splitByFolder(targets, opts); err != nil {
+			return err
+		}
+	}
+	out := opts.output()
+	for _, target := range targets {
+		if target.FolderPath == "" {
+			return fmt.Errorf("Please provide the image folder path using the -folder flag.")
+		}
+		imageFiles, err := getImageFiles(target.FolderPath, opts)
+		if err != nil {
+			return fmt.Errorf("Error collecting image files: %w", err)
+		}
+		for _, image := range imageFiles {
+			fmt.Fprintln(out, image.FilePath)
+		}
+	}
+	return nil
+}
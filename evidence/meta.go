@@ -0,0 +1,85 @@
+package evidence
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// metadataRows is the number of rows reserved for the metadata block: its
+// lines and an empty row before the first image
+const metadataRows = 5
+
+// reserveMetadata returns the options with the start cell moved below the
+// rows of the metadata block, which is written once the images are placed
+func reserveMetadata(opts Options) (Options, error) {
+	col, row, err := excelize.CellNameToCoordinates(opts.StartCell)
+	if err != nil {
+		return opts, fmt.Errorf("invalid starting cell: %w", err)
+	}
+	if opts.StartCell, err = excelize.CoordinatesToCellName(col, row+metadataRows); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// addMetadata writes a block describing the run into the cells starting at
+// cellName, with the number of images actually inserted
+func addMetadata(f *excelize.File, target SheetTarget, cellName string, count int) error {
+	col, row, err := excelize.CellNameToCoordinates(cellName)
+	if err != nil {
+		return fmt.Errorf("invalid starting cell: %w", err)
+	}
+	for i, line := range metadataLines(target.FolderPath, count) {
+		cell, err := excelize.CoordinatesToCellName(col, row+i)
+		if err != nil {
+			return err
+		}
+		if err := f.SetCellValue(target.SheetName, cell, line); err != nil {
+			return fmt.Errorf("failed to write metadata at %s: %w", cell, err)
+		}
+	}
+	return nil
+}
+
+// addTitle writes the sheet title in bold into the cell
+func addTitle(f *excelize.File, sheetName, cellName, title string) error {
+	style, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}})
+	if err != nil {
+		return fmt.Errorf("failed to create title style: %w", err)
+	}
+	if err := f.SetCellValue(sheetName, cellName, title); err != nil {
+		return fmt.Errorf("failed to write title at %s: %w", cellName, err)
+	}
+	if err := f.SetCellStyle(sheetName, cellName, cellName, style); err != nil {
+		return fmt.Errorf("failed to style title at %s: %w", cellName, err)
+	}
+	return nil
+}
+
+// metadataLines returns the label and value lines of the metadata block,
+// one less than metadataRows
+func metadataLines(folderPath string, count int) []string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	generatedBy := host
+	if u, err := user.Current(); err == nil {
+		generatedBy = u.Username + "@" + host
+	}
+	folder, err := filepath.Abs(folderPath)
+	if err != nil {
+		folder = folderPath
+	}
+	return []string{
+		"Generated: " + time.Now().Format("2006-01-02 15:04:05"),
+		"Generated by: " + generatedBy,
+		"Folder: " + folder,
+		fmt.Sprintf("Images: %d", count),
+	}
+}
@@ -0,0 +1,36 @@
+package evidence
+
+import (
+	"fmt"
+	"io"
+)
+
+// progress reports how many images of a sheet have been inserted, rewriting
+// a single line of the writer
+type progress struct {
+	w         io.Writer
+	sheetName string
+	done      int
+	total     int
+}
+
+// newProgress returns a progress reporter for the sheet's images, or nil
+// when w is nil
+func newProgress(w io.Writer, sheetName string, total int) *progress {
+	if w == nil {
+		return nil
+	}
+	return &progress{w: w, sheetName: sheetName, total: total}
+}
+
+// step records one more image, placed or skipped
+func (p *progress) step() {
+	if p == nil {
+		return
+	}
+	p.done++
+	fmt.Fprintf(p.w, "\r%s: %d/%d images", p.sheetName, p.done, p.total)
+	if p.done == p.total {
+		fmt.Fprintln(p.w)
+	}
+}
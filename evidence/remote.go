@@ -0,0 +1,69 @@
+package evidence
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// isURL reports whether the image list entry is an http:// or https:// URL
+// to fetch instead of a local path
+func isURL(s string) bool {
+	lower := strings.ToLower(s)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// openImage returns the original bytes of the image: its Source when it came
+// from an archive, the response body when it is a URL, otherwise the file.
+// URLs are fetched with a limit of opts.Timeout for the whole download, and
+// empty responses or those larger than opts.MaxFileSize are an error.
+func openImage(img ImageInfo, opts Options) (io.ReadCloser, error) {
+	switch {
+	case img.Source != nil:
+		return io.NopCloser(bytes.NewReader(img.Source)), nil
+	case isURL(img.FilePath):
+		client := &http.Client{Timeout: opts.Timeout}
+		resp, err := client.Get(img.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch image: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch image %s: %s", img.FilePath, resp.Status)
+		}
+		return fetchBody(img.FilePath, resp, opts)
+	default:
+		file, err := os.Open(img.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image file: %w", err)
+		}
+		return file, nil
+	}
+}
+
+// fetchBody reads the response body of an image URL, stopping as soon as it
+// is known to exceed opts.MaxFileSize
+func fetchBody(url string, resp *http.Response, opts Options) (io.ReadCloser, error) {
+	defer resp.Body.Close()
+	if resp.ContentLength >= 0 {
+		if reason := sizeProblem(resp.ContentLength, opts); reason != "" {
+			return nil, fmt.Errorf("image %s: %s", url, reason)
+		}
+	}
+	body := io.Reader(resp.Body)
+	if opts.MaxFileSize > 0 {
+		// One byte past the limit is enough to tell it was exceeded
+		body = io.LimitReader(body, int64(opts.MaxFileSize*(1<<20))+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image %s: %w", url, err)
+	}
+	if reason := sizeProblem(int64(len(data)), opts); reason != "" {
+		return nil, fmt.Errorf("image %s: %s", url, reason)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
@@ -1,210 +1,430 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"image"
-	_ "image/jpeg"
-	_ "image/png"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
-	"regexp"
-	"sort"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/xuri/excelize/v2"
+	"TestEvidenceCreator/evidence"
 )
 
-// ImageInfo holds image file details
-type ImageInfo struct {
-	FilePath string
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func main() {
+// run parses the command-line flags and inserts the images, returning the
+// first error encountered
+func run() error {
+	opts := evidence.DefaultOptions()
+
 	// Define flags for the image folder path and sheet name
-	folderPath := flag.String("folder", "", "Path to the folder containing images")
-	sheetName := flag.String("sheet", "", "Name of the sheet")
-	templatePath := flag.String("excel", "", "Name of the excel")
+	flag.StringVar(&opts.FolderPath, "folder", "", "Path to the folder containing images, or - to read newline-separated image paths from stdin")
+	flag.StringVar(&opts.SheetName, "sheet", "", "Name of the sheet")
+	flag.IntVar(&opts.SheetIndex, "sheet-index", opts.SheetIndex, "Zero-based position of the sheet, instead of -sheet (-1 for none)")
+	flag.StringVar(&opts.TemplateSheet, "template-sheet", "", "Copy this sheet, with its styles and print settings, into each new target sheet before inserting the images")
+	flag.BoolVar(&opts.CreateSheet, "create-sheet", false, "Create target sheets that do not exist in the workbook")
+	flag.IntVar(&opts.MaxPerSheet, "max-per-sheet", 0, "Continue on a new sheet named <sheet>_2, <sheet>_3 and so on after this many images (0 for no limit)")
+	flag.BoolVar(&opts.SplitByFolder, "split-by-folder", false, "Insert the images of each immediate subfolder of -folder into a sheet named after it, creating missing sheets")
+	batch := flag.String("batch", "", "Parent folder of run folders, each written to <out>/<run>.xlsx from a fresh copy of the template")
+	flag.BoolVar(&opts.SplitFiles, "split-files", false, "With -split-by-folder, write each subfolder to <out>/<subfolder>.xlsx instead of a sheet, treating -out as a directory")
+	targetCM := flag.String("target-cm", "", "Printed image size in centimeters as WIDTHxHEIGHT, e.g. 25x14, replacing -width and -height")
+	sheetMap := flag.String("map", "", "Comma-separated sheet=folder pairs to populate several sheets in one run")
+	flag.StringVar(&opts.TemplatePath, "excel", "", "Name of the excel")
+	flag.StringVar(&opts.OutPath, "out", "", "Path to write the updated workbook to (defaults to overwriting the template)")
+	flag.StringVar(&opts.Password, "password", "", "Password of an encrypted template, the saved workbook is encrypted with it too")
+	exportPDF := flag.Bool("pdf", false, "Also export the saved workbook to PDF using LibreOffice")
+	pdfPath := flag.String("pdf-out", "", "Path of the exported PDF (defaults to the workbook path with a .pdf extension)")
+	flag.StringVar(&opts.StartCell, "start", opts.StartCell, "Cell, or defined name of a cell, where the first image is inserted")
+	flag.BoolVar(&opts.Recursive, "recursive", opts.Recursive, "Include images in subfolders of the image folder")
+	flag.StringVar(&opts.SortBy, "sort", opts.SortBy, "Image order: name, natural, mtime or size")
+	flag.BoolVar(&opts.Reverse, "reverse", false, "Reverse the image order")
+	flag.StringVar(&opts.Glob, "glob", "", "Only include images matching this pattern, relative to the folder (supports ** for any subfolders)")
+	since := flag.String("since", "", "Only include images modified at or after this RFC3339 time, such as 2024-05-01T00:00:00Z, or this long ago, such as 24h")
+	until := flag.String("until", "", "Only include images modified at or before this RFC3339 time, or this long ago, such as 1h")
+	flag.IntVar(&opts.Skip, "skip", 0, "Number of images to skip from the start of the sorted list")
+	flag.IntVar(&opts.Limit, "limit", 0, "Maximum number of images to insert after sorting (0 for no limit)")
+	flag.BoolVar(&opts.Dedupe, "dedupe", false, "Skip images whose bytes are identical to an image already inserted")
+	flag.Float64Var(&opts.MaxFileSize, "max-file-size", 0, "Skip image files larger than this many megabytes, or stop with -fail-fast (0 for no limit)")
+	flag.BoolVar(&opts.FailFast, "fail-fast", false, "Stop with an error at empty image files, or files over -max-file-size, instead of skipping them with a warning")
+	flag.StringVar(&opts.PlacementFile, "placement", "", "CSV file of cell,filename pairs placing each listed image at its own cell instead of following the layout")
+	flag.StringVar(&opts.Manifest, "manifest", "", "CSV file with order,filename,caption columns listing the images to insert")
+	extensions := flag.String("ext", evidence.DefaultExtensions, "Comma-separated list of image file extensions to include")
+	flag.StringVar(&opts.Layout, "layout", opts.Layout, "Image layout: horizontal, vertical or grid")
+	flag.BoolVar(&opts.Append, "append", false, "Continue the layout after the images already in the sheet, such as from an earlier run, instead of at -start")
+	flag.IntVar(&opts.ColStep, "col-step", 0, "Number of columns to advance between images in horizontal layout, or per page in grid layout (0 computes it from -width and the sheet's column width)")
+	flag.Float64Var(&opts.Spacing, "spacing", 0, "In horizontal layout, advance past each image by the columns its scaled width times this covers, such as 1.1 for a 10% gap (0 uses -col-step)")
+	flag.BoolVar(&opts.ColumnsFromTemplate, "columns-from-template", false, "In horizontal layout, place one image per template column, scaled to fit the column's width")
+	flag.StringVar(&opts.Table, "table", "", "Name of an Excel table in the sheet; each data row receives one image, in the column of -start, fitted to the row height")
+	flag.IntVar(&opts.RowStep, "row-step", opts.RowStep, "Number of rows to advance between images in vertical layout, or per page in grid layout")
+	flag.IntVar(&opts.Cols, "cols", opts.Cols, "Number of image columns per page in grid layout")
+	flag.IntVar(&opts.Rows, "rows", opts.Rows, "Number of image rows per page in grid layout")
+	flag.BoolVar(&opts.CenterLastRow, "center-last-row", false, "Center the images of a partly filled last row in grid layout")
+	flag.BoolVar(&opts.GroupByPrefix, "group-by-prefix", false, "Label each group of images sharing a file name prefix and leave a gap between groups")
+	flag.StringVar(&opts.GroupDelimiters, "group-delimiter", opts.GroupDelimiters, "Characters ending the file name prefix used by -group-by-prefix, in addition to digits")
+	flag.IntVar(&opts.GroupGap, "group-gap", opts.GroupGap, "Extra columns (horizontal layout) or rows (vertical layout) between groups with -group-by-prefix")
+	flag.StringVar(&opts.PageBreak, "page-break", opts.PageBreak, "Page break mode: each, none or every-n")
+	flag.IntVar(&opts.BreakEvery, "break-every", opts.BreakEvery, "Number of images, or grid pages, per page break in every-n mode")
+	flag.IntVar(&opts.BreakRow, "break-row", opts.BreakRow, "Row of the page breaks in horizontal layout")
+	flag.Float64Var(&opts.Width, "width", opts.Width, "Desired image width in pixels")
+	flag.Float64Var(&opts.Height, "height", opts.Height, "Desired image height in pixels")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "Print the planned image placement without modifying any file")
+	flag.IntVar(&opts.Retry, "retry", 0, "Times to retry saving, with increasing waits, while the output file is open in Excel")
+	flag.BoolVar(&opts.ContinueOnError, "continue-on-error", false, "Skip images that cannot be read or inserted and report them at the end instead of stopping at the first one")
+	verbose := flag.Bool("v", false, "Log each image as it is processed")
+	jsonOutput := flag.Bool("json", false, "Print a JSON description of the inserted images instead of the success message")
+	quiet := flag.Bool("quiet", false, "Do not show the progress count on stderr")
+	list := flag.Bool("list", false, "Print the sorted and filtered image files that would be inserted, one per line, and exit")
+	check := flag.Bool("check", false, "Validate the template, sheets and start cell and print the sheet list without inserting images")
+	assumeYes := flag.Bool("y", false, "Modify the template file in place without asking when no -out file is given")
+	flag.IntVar(&opts.Jobs, "jobs", opts.Jobs, "Number of images read and decoded concurrently, and of workbooks written concurrently with -batch or -split-files")
+	flag.DurationVar(&opts.Timeout, "timeout", opts.Timeout, "Time limit for downloading each image listed as an http:// or https:// URL, such as 10s or 2m (0 for no limit)")
+	flag.IntVar(&opts.Quality, "quality", 0, "Re-encode images as JPEG at this quality (1-100) to shrink the workbook (0 embeds them unchanged)")
+	flag.IntVar(&opts.MaxDim, "max-dim", 0, "Downscale images whose longest side exceeds this many pixels before embedding (0 for no limit)")
+	flag.BoolVar(&opts.Stream, "stream", false, "Read and convert each image only when it is placed, lowering peak memory for large batches at the cost of -jobs parallelism")
+	flag.IntVar(&opts.WarnPixels, "warn-pixels", opts.WarnPixels, "Warn about images with more pixels than this (0 to never warn)")
+	flag.StringVar(&opts.FlattenBG, "flatten-bg", "", "Hex RGB color, e.g. FFFFFF, to composite transparent images onto before embedding")
+	flag.IntVar(&opts.SVGDPI, "svg-dpi", opts.SVGDPI, "Resolution SVG images are rasterized at, 96 for one pixel per display pixel")
+	flag.IntVar(&opts.GIFFrame, "gif-frame", 0, "Index of the frame inserted from animated GIFs (0 for the first frame)")
+	noAutoRotate := flag.Bool("no-autorotate", false, "Do not rotate images according to their EXIF orientation")
+	flag.IntVar(&opts.Rotate, "rotate", 0, "Rotate every image clockwise by 0, 90, 180 or 270 degrees, after any EXIF rotation")
+	flag.BoolVar(&opts.Caption, "caption", false, "Write each image's file name in the cell below it")
+	flag.BoolVar(&opts.TimestampCaption, "timestamp-caption", false, "Write each image's modification time in its caption cell, after the file name with -caption")
+	flag.StringVar(&opts.TimeFormat, "time-format", opts.TimeFormat, "Go time layout of -timestamp-caption, e.g. \"02 Jan 2006 15:04\"")
+	flag.BoolVar(&opts.Border, "border", false, "Frame the cells behind each image with a border")
+	flag.StringVar(&opts.BorderColor, "border-color", opts.BorderColor, "Hex RGB color of the -border frame")
+	flag.StringVar(&opts.BorderWidth, "border-width", opts.BorderWidth, "Width of the -border frame: thin, medium or thick")
+	flag.IntVar(&opts.CaptionOffset, "caption-offset", 0, "Rows between an image's cell and its caption (0 places it directly below the image, or one row above it)")
+	flag.StringVar(&opts.CaptionPosition, "caption-position", opts.CaptionPosition, "Where captions go: below the image, or above it with the image moved down by -caption-offset rows")
+	flag.BoolVar(&opts.Link, "link", false, "Set a hyperlink on each image's cell pointing to the source file")
+	flag.StringVar(&opts.LinkBase, "link-base", "", "Base URL the image paths are linked under with -link, instead of the absolute file path")
+	flag.BoolVar(&opts.CellComment, "cell-comment", false, "Attach a comment with the file name, pixel size and caption to each image's cell, shown when the cell is hovered")
+	flag.BoolVar(&opts.TOC, "toc", false, "Write a Contents sheet listing each image with a link to its cell")
+	flag.StringVar(&opts.Cover, "cover", "", "Excel file whose first sheet is copied in as the first sheet, replacing {{DATE}}, {{COUNT}} and {{TITLE}} in its cells")
+	flag.BoolVar(&opts.Meta, "meta", false, "Write the generation time, host, folder and image count at the start cell and insert the images below it")
+	flag.StringVar(&opts.Title, "title", "", "Title written in bold once per sheet, e.g. \"Login Regression 2024-06\"")
+	flag.StringVar(&opts.TitleCell, "title-cell", opts.TitleCell, "Cell the -title is written to")
+	flag.StringVar(&opts.CountCell, "count-cell", "", "Cell, or defined name of a cell, the number of images inserted into each sheet is written to")
+	flag.StringVar(&opts.Positioning, "positioning", "", "Image anchoring: oneCell (move with cells), twoCell (move and size with cells) or absolute (fixed); empty keeps the excelize default")
+	flag.IntVar(&opts.OffsetX, "offset-x", 0, "Pixels between the left edge of the image's cell and the image")
+	flag.IntVar(&opts.OffsetY, "offset-y", 0, "Pixels between the top edge of the image's cell and the image")
+	flag.BoolVar(&opts.PrintArea, "print-area", false, "Set each sheet's print area from A1 to the last inserted image or caption")
+	flag.BoolVar(&opts.PageNumbers, "page-numbers", false, "Number the printed pages as \"N of M\" in the center of the footer")
+	flag.StringVar(&opts.FooterLeft, "footer-left", "", "Text of the left printed footer section, may use Excel codes such as &D for the date")
+	flag.StringVar(&opts.FooterCenter, "footer-center", "", "Text of the center printed footer section, followed by the page number with -page-numbers")
+	flag.StringVar(&opts.FooterRight, "footer-right", "", "Text of the right printed footer section")
+	flag.BoolVar(&opts.FitCells, "fit-cells", false, "Resize the column and row of each image's cell to the image size so it fits on screen")
+	flag.BoolVar(&opts.AutoFit, "autofit", false, "Shrink each image to fit its cell, or merged cell range, instead of scaling it to -width x -height")
+	flag.BoolVar(&opts.PreserveAspect, "preserve-aspect", false, "Scale images uniformly to fit the desired size without distortion")
+
+	configPath := flag.String("config", "", "JSON file with default values for any of the other flags")
 
 	// Parse the command-line flags
 	flag.Parse()
 
-	// Validate inputs
-	if err := validateInputs(*folderPath, *sheetName, *templatePath); err != nil {
-		fmt.Println(err)
-		return
+	// Fill in flags not given on the command line from the config file, then
+	// from the environment, which takes precedence over the config file
+	setOnCommandLine := map[string]bool{}
+	flag.Visit(func(fl *flag.Flag) {
+		setOnCommandLine[fl.Name] = true
+	})
+	if *configPath != "" {
+		if err := applyConfigFile(flag.CommandLine, *configPath); err != nil {
+			return err
+		}
+	}
+	if err := applyEnvironment(flag.CommandLine, setOnCommandLine); err != nil {
+		return err
 	}
 
-	// Get sorted image files
-	imageFiles, err := getImageFiles(*folderPath)
-	if err != nil {
-		fmt.Printf("Error walking through the folder: %v\n", err)
-		return
+	if *verbose {
+		opts.Logger = log.New(os.Stderr, "", log.LstdFlags)
 	}
+	if !*quiet && !opts.DryRun && isTerminal(os.Stderr) {
+		opts.Progress = os.Stderr
+	}
+	var report jsonReport
+	if *jsonOutput {
+		report.DryRun = opts.DryRun
+		report.Placements = []evidence.Placement{}
+		report.Skipped = []evidence.Skip{}
+		opts.OnSkip = func(s evidence.Skip) {
+			report.Skipped = append(report.Skipped, s)
+		}
+		opts.Output = io.Discard
+		opts.OnPlace = func(p evidence.Placement) {
+			report.Placements = append(report.Placements, p)
+		}
+	}
+	opts.Extensions = evidence.ParseExtensions(*extensions)
+	opts.AutoRotate = !*noAutoRotate
 
-	// Open the existing Excel template file
-	f, err := openExcelFile(*templatePath)
-	if err != nil {
-		fmt.Printf("Failed to open template file: %v\n", err)
-		return
+	if *targetCM != "" {
+		var err error
+		if opts.WidthCM, opts.HeightCM, err = parseSize(*targetCM); err != nil {
+			return err
+		}
 	}
 
-	// Start inserting images at a specific row and column
-	startCell := "B4" // Starting position for the images
-	err = pasteImagesHorizontally(f, *sheetName, imageFiles, startCell)
-	if err != nil {
-		fmt.Printf("Error inserting images: %v\n", err)
-		return
+	now := time.Now()
+	for _, bound := range []struct {
+		value string
+		t     *time.Time
+	}{{*since, &opts.Since}, {*until, &opts.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		var err error
+		if *bound.t, err = parseTime(bound.value, now); err != nil {
+			return err
+		}
 	}
 
-	// Save the changes directly to the same file
-	if err := saveExcelFile(f); err != nil {
-		fmt.Printf("Failed to save updated file: %v\n", err)
-		return
+	if *sheetMap != "" {
+		var err error
+		if opts.Sheets, err = parseSheetMap(*sheetMap); err != nil {
+			return err
+		}
 	}
 
-	fmt.Println("Images inserted successfully into the template file:", *templatePath)
-}
+	// A batch is a split into files, one per child folder of the parent
+	if *batch != "" {
+		if opts.FolderPath != "" || opts.Sheets != nil {
+			return fmt.Errorf("The -batch flag cannot be combined with -folder or -map.")
+		}
+		opts.FolderPath, opts.SplitByFolder, opts.SplitFiles = *batch, true, true
+	}
 
-// validateInputs checks if the provided folder, sheet, and excel file paths are valid.
-func validateInputs(folderPath, sheetName, templatePath string) error {
-	if folderPath == "" {
-		return fmt.Errorf("Please provide the image folder path using the -folder flag.")
+	if opts.SplitFiles && *exportPDF {
+		return fmt.Errorf("The -pdf flag cannot be combined with -split-files.")
 	}
-	if sheetName == "" {
-		return fmt.Errorf("Please provide the sheet name using the -sheet flag.")
+
+	// Messages go to stderr when stdout carries the JSON report
+	messages := os.Stdout
+	if *jsonOutput {
+		messages = os.Stderr
 	}
-	if templatePath == "" {
-		return fmt.Errorf("Please provide the excel file path using the -excel flag.")
+
+	if *list {
+		opts.Output = os.Stdout
+		return evidence.List(opts)
 	}
-	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
-		return fmt.Errorf("The folder path does not exist: %s", folderPath)
+	if *check {
+		return evidence.Check(opts)
 	}
-	return nil
-}
 
-// getImageFiles walks through the folder and returns sorted image files
-func getImageFiles(folderPath string) ([]ImageInfo, error) {
-	var imageFiles []string
-	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			fileName := filepath.Base(path)
-			imageFiles = append(imageFiles, fileName)
+	if !opts.DryRun && opts.TemplatePath != "" && opts.OutPath == "" {
+		if !*assumeYes {
+			if err := confirmOverwrite(opts.TemplatePath); err != nil {
+				return err
+			}
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
+		// Like the library warnings, this goes to stderr to keep stdout for results
+		fmt.Fprintln(os.Stderr, "Warning: no -out file given, modifying the template file in place:", opts.TemplatePath)
+	}
+	// With -continue-on-error the workbook is saved without the skipped
+	// images, which are reported before returning the error
+	insertErr := evidence.Insert(opts)
+	if insertErr != nil && !errors.Is(insertErr, evidence.ErrSkipped) {
+		return insertErr
 	}
 
-	// Sort the image files based on numbers in the filenames
-	sort.Slice(imageFiles, func(i, j int) bool {
-		re := regexp.MustCompile(`\d+`)
-		hasNumI := re.MatchString(imageFiles[i])
-		hasNumJ := re.MatchString(imageFiles[j])
+	savedPath := opts.TemplatePath
+	if opts.OutPath != "" {
+		savedPath = opts.OutPath
+	}
+	inserted := "Images inserted successfully"
+	if insertErr != nil {
+		inserted = "Images inserted, except those skipped,"
+	}
+	switch {
+	case opts.DryRun || *jsonOutput:
+	case opts.SplitFiles:
+		fmt.Println(inserted, "into the workbooks in:", opts.OutPath)
+	case opts.OutPath != "":
+		fmt.Println(inserted, "into the output file:", opts.OutPath)
+	default:
+		fmt.Println(inserted, "into the template file:", opts.TemplatePath)
+	}
 
-		if hasNumI && !hasNumJ {
-			return false
-		} else if !hasNumI && hasNumJ {
-			return true
+	if *exportPDF && !opts.DryRun {
+		target := *pdfPath
+		if target == "" {
+			target = strings.TrimSuffix(savedPath, filepath.Ext(savedPath)) + ".pdf"
 		}
-		return imageFiles[i] < imageFiles[j]
-	})
-
-	var images []ImageInfo
-	for _, fileName := range imageFiles {
-		images = append(images, ImageInfo{FilePath: folderPath + fileName})
+		if err := evidence.ConvertToPDF(savedPath, target); err != nil {
+			return fmt.Errorf("Failed to export PDF: %v", err)
+		}
+		fmt.Fprintln(messages, "PDF exported to:", target)
+		report.PDF = target
 	}
-	return images, nil
-}
 
-// openExcelFile opens the specified Excel template file
-func openExcelFile(templatePath string) (*excelize.File, error) {
-	return excelize.OpenFile(templatePath)
+	if *jsonOutput {
+		if !opts.DryRun {
+			report.Output = savedPath
+		}
+		report.Images = len(report.Placements)
+		sheets := map[string]bool{}
+		for _, p := range report.Placements {
+			sheets[p.Sheet] = true
+		}
+		report.Sheets = len(sheets)
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	}
+	return insertErr
 }
 
-// saveExcelFile saves the Excel file
-func saveExcelFile(f *excelize.File) error {
-	return f.Save()
+// jsonReport is the machine-readable description of a run printed with -json
+type jsonReport struct {
+	Output     string               `json:"output,omitempty"`
+	PDF        string               `json:"pdf,omitempty"`
+	DryRun     bool                 `json:"dry_run"`
+	Images     int                  `json:"images"`
+	Sheets     int                  `json:"sheets"`
+	Placements []evidence.Placement `json:"placements"`
+	Skipped    []evidence.Skip      `json:"skipped"`
 }
 
-// pasteImagesHorizontally places images horizontally in the Excel sheet
-func pasteImagesHorizontally(f *excelize.File, sheetName string, images []ImageInfo, startCell string) error {
-	currentCol, row, err := excelize.CellNameToCoordinates(startCell)
+// applyConfigFile reads a JSON object mapping flag names to values and sets
+// each flag that was not already given on the command line
+func applyConfigFile(flags *flag.FlagSet, configPath string) error {
+	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return fmt.Errorf("invalid starting cell: %v", err)
+		return fmt.Errorf("Failed to read config file: %v", err)
+	}
+	// Keep numbers as written, as float64 would print 50000000 as 5e+07
+	var values map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("Failed to parse config file %s: %v", configPath, err)
 	}
 
-	desiredWidth := 1115.9 // Desired width in pixels
-	desiredHeight := 609.2 // Desired height in pixels
-
-	for index, img := range images {
-		cellName, _ := excelize.CoordinatesToCellName(currentCol, row)
+	setOnCommandLine := map[string]bool{}
+	flags.Visit(func(fl *flag.Flag) {
+		setOnCommandLine[fl.Name] = true
+	})
 
-		// Get original dimensions of the image
-		originalWidth, originalHeight, err := getDimensions(img.FilePath)
-		if err != nil {
-			return fmt.Errorf("failed to get image dimensions: %v", err)
+	for name, value := range values {
+		if flags.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("Unknown flag %q in config file %s", name, configPath)
 		}
-
-		// Calculate scaling factors
-		scaleX := float64(desiredWidth) / float64(originalWidth)
-		scaleY := float64(desiredHeight) / float64(originalHeight)
-
-		// Add the image at the current position
-		err = addImage(f, sheetName, img.FilePath, cellName, scaleX, scaleY)
-		if err != nil {
-			return fmt.Errorf("failed to insert image %s: %v", img.FilePath, err)
+		if setOnCommandLine[name] {
+			continue
 		}
+		if err := flags.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("Invalid value for %q in config file %s: %v", name, configPath, err)
+		}
+	}
+	return nil
+}
 
-		// Move to the next column with spacing
-		currentCol += 37
+// envFlags lists the environment variables read in place of flags, with the
+// flags they set and the flags that pick the same target another way
+var envFlags = []struct {
+	env, flag string
+	unless    []string
+}{
+	{"EVIDENCE_FOLDER", "folder", []string{"batch", "map"}},
+	{"EVIDENCE_SHEET", "sheet", []string{"sheet-index", "map"}},
+	{"EVIDENCE_EXCEL", "excel", nil},
+}
 
-		// Insert a page break after the current image except for the last one
-		if index > 0 {
-			pageBreakCell, _ := excelize.CoordinatesToCellName(currentCol-1, 40)
-			err = f.InsertPageBreak(sheetName, pageBreakCell)
-			if err != nil {
-				return fmt.Errorf("failed to insert page break at %s: %v", pageBreakCell, err)
-			}
+// applyEnvironment sets the flags of envFlags that were not given on the
+// command line from their non-empty environment variables, over any value
+// from the config file. A variable is ignored when one of the flags in unless
+// was given on the command line or in the config file.
+func applyEnvironment(flags *flag.FlagSet, setOnCommandLine map[string]bool) error {
+	set := map[string]bool{}
+	flags.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+
+	for _, ef := range envFlags {
+		value := os.Getenv(ef.env)
+		given := func(name string) bool { return set[name] }
+		if value == "" || setOnCommandLine[ef.flag] || slices.ContainsFunc(ef.unless, given) {
+			continue
+		}
+		if err := flags.Set(ef.flag, value); err != nil {
+			return fmt.Errorf("Invalid value for %s in the environment: %v", ef.env, err)
 		}
 	}
 	return nil
 }
 
-// addImage adds an image at a specific cell in the Excel sheet
-func addImage(f *excelize.File, sheetName, filePath, cell string, scaleX, scaleY float64) error {
-	imgBytes, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read image file: %v", err)
+// isTerminal reports whether the file is a terminal rather than a pipe or a
+// regular file
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmOverwrite asks on the terminal whether the template file may be
+// modified in place, and refuses when there is no terminal to ask on
+func confirmOverwrite(templatePath string) error {
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("Refusing to modify the template file %s in place without -out. Pass -y to confirm.", templatePath)
 	}
+	fmt.Fprintf(os.Stderr, "No -out file given, modify the template file %s in place? [y/N] ", templatePath)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("Aborted, the template file was not modified.")
+}
 
-	err = f.AddPictureFromBytes(sheetName, cell, &excelize.Picture{
-		Extension: ".png", // Ensure the file extension matches
-		File:      imgBytes,
-		Format: &excelize.GraphicOptions{
-			ScaleX:  scaleX,
-			ScaleY:  scaleY,
-			AutoFit: false,
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to insert image: %v", err)
+// parseSize parses a WIDTHxHEIGHT size
+func parseSize(value string) (float64, float64, error) {
+	w, h, ok := strings.Cut(strings.ToLower(value), "x")
+	width, errW := strconv.ParseFloat(strings.TrimSpace(w), 64)
+	height, errH := strconv.ParseFloat(strings.TrimSpace(h), 64)
+	if !ok || errW != nil || errH != nil {
+		return 0, 0, fmt.Errorf("Invalid size %q, expected WIDTHxHEIGHT such as 25x14.", value)
 	}
-	return nil
+	return width, height, nil
 }
 
-func getDimensions(filePath string) (int, int, error) {
-	imgFile, err := os.Open(filePath)
-	if err != nil {
-		return 0, 0, err
+// parseTime parses an RFC3339 timestamp, or a duration such as 24h meaning
+// that long before now
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
 	}
-	defer imgFile.Close()
-	img, _, err := image.Decode(imgFile)
-	if err != nil {
-		return 0, 0, err
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %q, expected an RFC3339 timestamp such as 2024-05-01T00:00:00Z or a duration such as 24h.", value)
+}
+
+// parseSheetMap parses comma-separated sheet=folder pairs
+func parseSheetMap(value string) ([]evidence.SheetTarget, error) {
+	var targets []evidence.SheetTarget
+	for _, pair := range strings.Split(value, ",") {
+		sheetName, folderPath, ok := strings.Cut(pair, "=")
+		sheetName, folderPath = strings.TrimSpace(sheetName), strings.TrimSpace(folderPath)
+		if !ok || sheetName == "" || folderPath == "" {
+			return nil, fmt.Errorf("Invalid -map entry %q, expected sheet=folder.", pair)
+		}
+		targets = append(targets, evidence.SheetTarget{SheetName: sheetName, FolderPath: folderPath})
 	}
-	return img.Bounds().Max.X, img.Bounds().Max.Y, nil
+	return targets, nil
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyConfigFileLargeNumbers(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "evidence.json")
+	config := `{"warn-pixels": 50000000, "max-file-size": 2500000.5, "width": 1115.9}`
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	flags := flag.NewFlagSet("evidence", flag.ContinueOnError)
+	warnPixels := flags.Int("warn-pixels", 0, "")
+	maxFileSize := flags.Float64("max-file-size", 0, "")
+	width := flags.Float64("width", 0, "")
+	if err := applyConfigFile(flags, configPath); err != nil {
+		t.Fatal(err)
+	}
+	if *warnPixels != 50000000 || *maxFileSize != 2500000.5 || *width != 1115.9 {
+		t.Errorf("got warn-pixels %d, max-file-size %g, width %g", *warnPixels, *maxFileSize, *width)
+	}
+}
+
+func TestApplyEnvironmentSkipsOtherTargets(t *testing.T) {
+	t.Setenv("EVIDENCE_FOLDER", "Images/1/")
+	t.Setenv("EVIDENCE_SHEET", "#1")
+	tests := []struct {
+		args          []string
+		config        string
+		folder, sheet string
+	}{
+		{nil, "", "Images/1/", "#1"},
+		{[]string{"-folder", "Images/2/"}, "", "Images/2/", "#1"},
+		{[]string{"-batch", "runs"}, "", "", "#1"},
+		{[]string{"-manifest", "order.csv"}, "", "Images/1/", "#1"},
+		{[]string{"-sheet-index", "0"}, "", "Images/1/", ""},
+		{[]string{"-map", "#1=Images/1/"}, "", "", ""},
+		{nil, `{"folder": "Images/2/", "sheet": "#2"}`, "Images/1/", "#1"},
+		{nil, `{"batch": "runs"}`, "", "#1"},
+		{nil, `{"sheet-index": 0}`, "Images/1/", ""},
+	}
+	for _, tt := range tests {
+		flags := flag.NewFlagSet("evidence", flag.ContinueOnError)
+		folder := flags.String("folder", "", "")
+		sheet := flags.String("sheet", "", "")
+		flags.String("excel", "", "")
+		flags.String("batch", "", "")
+		flags.String("map", "", "")
+		flags.String("manifest", "", "")
+		flags.Int("sheet-index", -1, "")
+		if err := flags.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		setOnCommandLine := map[string]bool{}
+		flags.Visit(func(fl *flag.Flag) {
+			setOnCommandLine[fl.Name] = true
+		})
+		if tt.config != "" {
+			configPath := filepath.Join(t.TempDir(), "evidence.json")
+			if err := os.WriteFile(configPath, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyConfigFile(flags, configPath); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := applyEnvironment(flags, setOnCommandLine); err != nil {
+			t.Fatal(err)
+		}
+		if *folder != tt.folder || *sheet != tt.sheet {
+			t.Errorf("%v %s: got folder %q, sheet %q, want %q, %q", tt.args, tt.config, *folder, *sheet, tt.folder, tt.sheet)
+		}
+	}
+}